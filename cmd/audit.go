@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/audit"
+)
+
+// runAudit 对比端口状态与集群中的 Services，可选自动修正位图
+func runAudit(args []string) error {
+	var configFile, output string
+	var fix bool
+	fs := newCommandFlags("audit", &configFile)
+	fs.StringVar(&output, "output", "table", "输出格式 table 或 json")
+	fs.BoolVar(&fix, "fix", false, "修正位图中与集群不一致的端口标记")
+	_ = fs.Parse(args)
+
+	if output != "table" && output != "json" {
+		return fmt.Errorf("不支持的输出格式 %s", output)
+	}
+
+	cfg, c, storage, err := newCommandStorage(configFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	report, err := audit.Run(ctx, storage, c, cfg)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else if err := report.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+
+	remaining := len(report.Findings)
+	if fix {
+		fixed, err := audit.Fix(ctx, storage, cfg, report)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "已修正 %d 个端口标记\n", fixed)
+		for _, finding := range report.Findings {
+			if finding.Fixable() {
+				remaining--
+			}
+		}
+	}
+
+	if remaining > 0 {
+		return fmt.Errorf("存在 %d 处需要处理的问题", remaining)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/backup"
+)
+
+// runExport 导出端口分配状态
+func runExport(args []string) error {
+	var configFile, output, format string
+	fs := newCommandFlags("export", &configFile)
+	fs.StringVar(&output, "output", "-", "快照输出文件，- 表示标准输出")
+	fs.StringVar(&format, "format", "", "快照格式 json 或 yaml，默认根据文件扩展名推断")
+	_ = fs.Parse(args)
+
+	cfg, c, storage, err := newCommandStorage(configFile)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := backup.Export(context.Background(), storage, c, cfg)
+	if err != nil {
+		return err
+	}
+
+	if format == "" {
+		format = backup.FormatForPath(output)
+	}
+
+	w, err := openOutput(output)
+	if err != nil {
+		return fmt.Errorf("打开输出文件失败: %v", err)
+	}
+	defer w.Close()
+
+	return snapshot.Write(w, format)
+}
+
+// runVerifyExport 将快照与当前配置和集群中的 Services 对比
+func runVerifyExport(args []string) error {
+	var configFile, input, format string
+	fs := newCommandFlags("verify-export", &configFile)
+	fs.StringVar(&input, "input", "-", "快照文件，- 表示标准输入")
+	fs.StringVar(&format, "format", "", "快照格式 json 或 yaml，默认根据文件扩展名推断")
+	_ = fs.Parse(args)
+
+	cfg, c, _, err := newCommandStorage(configFile)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := readSnapshot(input, format)
+	if err != nil {
+		return err
+	}
+
+	findings, err := backup.Verify(context.Background(), snapshot, c, cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, finding := range findings {
+		fmt.Printf("range=%s port=%d: %s\n", finding.Range, finding.Port, finding.Message)
+	}
+	if len(findings) > 0 {
+		return fmt.Errorf("快照与集群不一致，共 %d 处", len(findings))
+	}
+
+	fmt.Println("快照与集群一致")
+	return nil
+}
+
+// runImport 导入快照，默认只输出差异（dry-run）
+func runImport(args []string) error {
+	var configFile, input, format, mode string
+	var dryRun bool
+	fs := newCommandFlags("import", &configFile)
+	fs.StringVar(&input, "input", "-", "快照文件，- 表示标准输入")
+	fs.StringVar(&format, "format", "", "快照格式 json 或 yaml，默认根据文件扩展名推断")
+	fs.StringVar(&mode, "mode", backup.ImportModeMerge, "导入方式：merge 追加到现有状态，replace 覆盖现有状态")
+	fs.BoolVar(&dryRun, "dry-run", true, "只输出差异，不写入存储")
+	_ = fs.Parse(args)
+
+	cfg, _, storage, err := newCommandStorage(configFile)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := readSnapshot(input, format)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	current, corrupted, err := backup.CurrentState(ctx, storage, cfg)
+	if err != nil {
+		return err
+	}
+	for _, name := range corrupted {
+		fmt.Fprintf(os.Stderr, "警告: 端口范围 %s 的现有状态已损坏，按空范围对比\n", name)
+	}
+
+	diffs := backup.Diff(current, snapshot, mode)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(diffs); err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Fprintln(os.Stderr, "dry-run 模式，未写入存储；确认差异后使用 --dry-run=false 执行导入")
+		return nil
+	}
+
+	if err := backup.Import(ctx, storage, snapshot, cfg, mode); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "导入完成，共 %d 个端口范围发生变化\n", len(diffs))
+	return nil
+}
+
+// readSnapshot 读取快照文件
+func readSnapshot(input, format string) (*backup.Snapshot, error) {
+	if format == "" {
+		format = backup.FormatForPath(input)
+	}
+
+	r, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("打开快照文件失败: %v", err)
+	}
+	defer r.Close()
+
+	return backup.ReadSnapshot(r, format)
+}
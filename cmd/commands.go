@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+	"github.com/tiggoins/nodeport-allocator/pkg/utils"
+)
+
+// subcommands 运维子命令，未指定子命令时以 webhook 服务方式运行
+var subcommands = map[string]func(args []string) error{
+	"export":        runExport,
+	"verify-export": runVerifyExport,
+	"import":        runImport,
+	"audit":         runAudit,
+	"validate":      runValidate,
+	"simulate":      runSimulate,
+	"uninstall":     runUninstall,
+}
+
+// newCommandFlags 创建子命令的参数集，包含公共的 --config 参数
+func newCommandFlags(name string, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(configFile, "config", "config/config.yaml", "配置文件路径")
+	return fs
+}
+
+// newCommandClient 创建直连 apiserver 的客户端，子命令不依赖 informer 缓存
+func newCommandClient() (client.Client, error) {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("获取 kubeconfig 失败: %v", err)
+	}
+
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("创建客户端失败: %v", err)
+	}
+	return c, nil
+}
+
+// newCommandStorage 加载配置并创建存储
+func newCommandStorage(configFile string) (*config.Config, client.Client, *portmanager.Storage, error) {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	c, err := newCommandClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	storage, err := portmanager.NewStorage(c, c, &cfg.StorageConfig, nil, utils.NewLogger("storage"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, c, storage, nil
+}
+
+// openOutput 打开输出文件，"-" 表示标准输出
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// openInput 打开输入文件，"-" 表示标准输入
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
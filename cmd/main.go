@@ -2,24 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/tiggoins/nodeport-allocator/pkg/admission"
+	"github.com/tiggoins/nodeport-allocator/pkg/certs"
 	"github.com/tiggoins/nodeport-allocator/pkg/config"
 	"github.com/tiggoins/nodeport-allocator/pkg/controller"
+	"github.com/tiggoins/nodeport-allocator/pkg/forward"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
 	"github.com/tiggoins/nodeport-allocator/pkg/leader"
 	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
 	"github.com/tiggoins/nodeport-allocator/pkg/utils"
@@ -36,6 +44,17 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			ctrl.SetLogger(zap.New(zap.WriteTo(os.Stderr)))
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var configFile string
 	var enableLeaderElection bool
 	var metricsAddr string
@@ -62,6 +81,8 @@ func main() {
 		setupLog.Error(err, "加载配置失败")
 		os.Exit(1)
 	}
+	language, _ := i18n.Parse(cfg.Language)
+	i18n.SetDefault(language)
 
 	// 创建 Manager
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
@@ -79,8 +100,31 @@ func main() {
 	}
 
 	ctx := setupSignalHandler()
+
+	// 自管理证书：在 Webhook 服务器启动前写入证书文件，并注册 Webhook 配置
+	if cfg.Webhook.SelfManaged {
+		if err := setupCertificates(ctx, mgr, cfg, webhookCertDir); err != nil {
+			setupLog.Error(err, "设置Webhook证书失败")
+			os.Exit(1)
+		}
+	}
+
+	// 自动发现集群的 NodePort 范围，发现失败时沿用配置值
+	if cfg.DiscoverNodePortRange {
+		nodePortRange, err := discoverNodePortRange(ctx, mgr.GetAPIReader())
+		if err != nil {
+			setupLog.Error(err, "自动发现NodePort范围失败，使用配置值", "nodePortRange", cfg.NodePortRange)
+		} else if err := cfg.ApplyNodePortRange(nodePortRange); err != nil {
+			setupLog.Error(err, "应用集群NodePort范围失败")
+			os.Exit(1)
+		} else {
+			setupLog.Info("已发现集群NodePort范围", "nodePortRange", cfg.NodePortRange)
+		}
+	}
+
 	// 创建端口管理器
-	portManager, err := portmanager.NewManager(ctx, mgr.GetClient(), cfg, utils.NewLogger("portmanager"))
+	portManager, err := portmanager.NewManager(ctx, mgr.GetClient(), mgr.GetAPIReader(), cfg,
+		mgr.GetEventRecorderFor("nodeport-allocator"), utils.NewLogger("portmanager"))
 	if err != nil {
 		setupLog.Error(err, "创建端口管理器失败")
 		os.Exit(1)
@@ -91,20 +135,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 扫描现有的NodePort Services并初始化端口状态
-	if err := portManager.ScanExistingServices(ctx); err != nil {
-		setupLog.Error(err, "扫描现有NodePort Services失败")
+	if cfg.HighAvailability.Mode == config.HAModeLeader {
+		// Leader 转发模式：仅 Leader 修改端口状态，由 Leader 在当选后扫描现有 Services
+		if err := setupLeaderForwarding(mgr, cfg, portManager); err != nil {
+			setupLog.Error(err, "设置Leader转发模式失败")
+			os.Exit(1)
+		}
+	} else {
+		// 扫描现有的NodePort Services并初始化端口状态
+		if err := portManager.ScanExistingServices(ctx); err != nil {
+			setupLog.Error(err, "扫描现有NodePort Services失败")
+			os.Exit(1)
+		}
+	}
+	portManager.MarkStateLoaded()
+
+	// 监听端口状态ConfigMap，保持各副本内存缓存与存储一致
+	if err := (&controller.StateReconciler{
+		PortManager: portManager,
+		Logger:      utils.NewLogger("state"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "设置端口状态控制器失败")
 		os.Exit(1)
 	}
 
 	// 设置 webhook
 	webhookServer := mgr.GetWebhookServer()
-	mutator := admission.NewMutator(portManager, utils.NewLogger("mutator"))
-	webhookServer.Register("/mutate", &webhook.AdmissionHandler{
+	mutator := admission.NewMutator(portManager, mgr.GetClient(), utils.NewLogger("mutator"))
+	webhookServer.Register(webhook.MutatePath, &webhook.AdmissionHandler{
 		Handler: mutator,
 		Logger:  utils.NewLogger("webhook"),
 	})
 
+	// 校验 Webhook 对经过所有变更 Webhook 后的最终对象重新检查端口
+	validator := admission.NewValidator(portManager, mgr.GetClient(), utils.NewLogger("validator"))
+	webhookServer.Register(webhook.ValidatePath, &webhook.AdmissionHandler{
+		Handler: validator,
+		Logger:  utils.NewLogger("webhook"),
+	})
+
 	// 设置控制器（仅在 Leader 模式下运行）
 	if enableLeaderElection {
 		if err = setupControllerWithLeaderElection(mgr, portManager); err != nil {
@@ -118,15 +187,25 @@ func main() {
 		}
 	}
 
-	// 添加健康检查
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+	// 添加健康检查：端口范围的锁卡住时重启容器
+	if err := mgr.AddHealthzCheck("allocator", portManager.LivenessCheck); err != nil {
 		setupLog.Error(err, "添加健康检查失败")
 		os.Exit(1)
 	}
 
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "添加就绪检查失败")
-		os.Exit(1)
+	// 就绪检查：端口状态已加载、informer 缓存已同步、存储可访问（failurePolicy=Ignore 时以降级模式继续服务），
+	// 且 Webhook TLS 服务已开始监听
+	readyChecks := map[string]healthz.Checker{
+		"state":   portManager.StateReadyCheck,
+		"cache":   cacheSyncedCheck(mgr.GetCache()),
+		"storage": portManager.StorageReadyCheck,
+		"webhook": webhookServer.StartedChecker(),
+	}
+	for name, check := range readyChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			setupLog.Error(err, "添加就绪检查失败", "check", name)
+			os.Exit(1)
+		}
 	}
 
 	setupLog.Info("启动 manager")
@@ -136,12 +215,32 @@ func main() {
 	}
 }
 
+// discoverNodePortRange 从 kube-apiserver 的启动参数发现 NodePort 范围
+func discoverNodePortRange(ctx context.Context, reader client.Reader) (config.NodePortRange, error) {
+	value, err := utils.DiscoverNodePortRange(ctx, reader)
+	if err != nil {
+		return config.NodePortRange{}, err
+	}
+	return config.ParseNodePortRange(value)
+}
+
 func setupController(mgr manager.Manager, portManager *portmanager.Manager) error {
-	return (&controller.ServiceReconciler{
+	if err := (&controller.ServiceReconciler{
 		Client:      mgr.GetClient(),
 		Scheme:      mgr.GetScheme(),
 		PortManager: portManager,
+		Recorder:    mgr.GetEventRecorderFor("nodeport-allocator"),
 		Logger:      utils.NewLogger("controller"),
+	}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+
+	// 命名空间删除时批量回收端口
+	return (&controller.NamespaceReconciler{
+		Client:      mgr.GetClient(),
+		PortManager: portManager,
+		Recorder:    mgr.GetEventRecorderFor("nodeport-allocator"),
+		Logger:      utils.NewLogger("namespace"),
 	}).SetupWithManager(mgr)
 }
 
@@ -168,6 +267,108 @@ func setupControllerWithLeaderElection(mgr manager.Manager, portManager *portman
 	return mgr.Add(leaderElection)
 }
 
+// setupLeaderForwarding 设置 Leader 转发模式：所有副本参与分配 Leader 选举，
+// Leader 提供内部转发服务，Follower 将分配/释放请求转发给 Leader
+func setupLeaderForwarding(mgr manager.Manager, cfg *config.Config, portManager *portmanager.Manager) error {
+	forwardConfig := cfg.HighAvailability.LeaderForward
+
+	token, err := forward.ReadToken(forwardConfig.TokenFile)
+	if err != nil {
+		return err
+	}
+
+	timeout, err := time.ParseDuration(forwardConfig.Timeout)
+	if err != nil {
+		return fmt.Errorf("解析转发超时失败: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	election := leader.NewElection(
+		clientset,
+		forwardConfig.LeaseName,
+		func(ctx context.Context) {
+			// 新 Leader 先清空启动时已损坏的端口范围，再以存储为准刷新缓存，并补齐现有 Services 的端口状态
+			if err := portManager.RebuildCorrupted(ctx); err != nil {
+				setupLog.Error(err, "Leader 重建损坏的端口范围失败")
+			}
+			if err := portManager.Refresh(ctx); err != nil {
+				setupLog.Error(err, "Leader 刷新端口状态失败")
+			}
+			if err := portManager.ScanExistingServices(ctx); err != nil {
+				setupLog.Error(err, "Leader 扫描现有NodePort Services失败")
+			}
+		},
+		utils.NewLogger("allocation-leader"),
+	).RunOnAllReplicas()
+
+	if err := mgr.Add(election); err != nil {
+		return err
+	}
+
+	server := forward.NewServer(forwardConfig.Port, token, election, portManager, utils.NewLogger("forward-server"))
+	if err := mgr.Add(server); err != nil {
+		return err
+	}
+
+	portManager.SetForwarder(forward.NewClient(election, mgr.GetAPIReader(), forwardConfig.Port, token, timeout, utils.NewLogger("forward-client")))
+	return nil
+}
+
+// setupCertificates 签发或加载 Webhook 证书并注册 Webhook 配置，之后定期检查并在到期前轮换证书
+// 证书文件更新后由 Webhook 服务器的证书监听重新加载，无需重启
+func setupCertificates(ctx context.Context, mgr manager.Manager, cfg *config.Config, certDir string) error {
+	webhookConfig := cfg.Webhook
+	validity, err := time.ParseDuration(webhookConfig.CertValidity)
+	if err != nil {
+		return fmt.Errorf("解析证书有效期失败: %v", err)
+	}
+	rotateBefore, err := time.ParseDuration(webhookConfig.RotateBefore)
+	if err != nil {
+		return fmt.Errorf("解析证书轮换时间失败: %v", err)
+	}
+
+	registrar := &webhook.Registrar{
+		Client: mgr.GetClient(),
+		Reader: mgr.GetAPIReader(),
+		Config: webhookConfig,
+		Logger: utils.NewLogger("webhook-registrar"),
+	}
+	certManager := &certs.Manager{
+		Client:        mgr.GetClient(),
+		Reader:        mgr.GetAPIReader(),
+		Namespace:     webhookConfig.ServiceNamespace,
+		SecretName:    webhookConfig.SecretName,
+		DNSNames:      certs.DNSNames(webhookConfig.ServiceName, webhookConfig.ServiceNamespace),
+		CertDir:       certDir,
+		Validity:      validity,
+		RotateBefore:  rotateBefore,
+		CheckInterval: certs.DefaultCheckInterval,
+		OnCABundle:    registrar.Register,
+		Logger:        utils.NewLogger("certs"),
+	}
+
+	if err := certManager.Ensure(ctx); err != nil {
+		return err
+	}
+	return mgr.Add(certManager)
+}
+
+// cacheSyncedCheck 就绪检查：informer 缓存启动并完成同步前返回错误
+func cacheSyncedCheck(informers cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+		defer cancel()
+		if !informers.WaitForCacheSync(ctx) {
+			return errors.New("informer 缓存尚未同步")
+		}
+		return nil
+	}
+}
+
 func setupSignalHandler() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/controller"
+)
+
+// runUninstall 移除所有 Service 上本分配器添加的 Finalizer，可选删除端口状态存储
+// 执行前应先停止分配器并删除 MutatingWebhookConfiguration，否则 Finalizer 会被重新添加
+func runUninstall(args []string) error {
+	var configFile string
+	var dryRun, deleteState bool
+	fs := newCommandFlags("uninstall", &configFile)
+	fs.BoolVar(&dryRun, "dry-run", false, "只输出将要执行的修改，不实际写入")
+	fs.BoolVar(&deleteState, "delete-state", false, "同时删除端口状态ConfigMap（包括分片）")
+	_ = fs.Parse(args)
+
+	cfg, c, storage, err := newCommandStorage(configFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var services corev1.ServiceList
+	if err := c.List(ctx, &services); err != nil {
+		return fmt.Errorf("列出Services失败: %v", err)
+	}
+
+	removed := 0
+	for i := range services.Items {
+		service := &services.Items[i]
+		original := service.DeepCopy()
+		if !controller.RemoveServiceFinalizers(service, cfg) {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "移除Finalizer: %s/%s\n", service.Namespace, service.Name)
+		removed++
+		if dryRun {
+			continue
+		}
+		if err := c.Patch(ctx, service, client.MergeFrom(original)); err != nil {
+			return fmt.Errorf("移除 Service %s/%s 的Finalizer失败: %v", service.Namespace, service.Name, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "已处理 %d 个Service的Finalizer\n", removed)
+
+	if !deleteState {
+		return nil
+	}
+
+	var configMaps corev1.ConfigMapList
+	if err := c.List(ctx, &configMaps, client.InNamespace(cfg.StorageConfig.ConfigMapNamespace)); err != nil {
+		return fmt.Errorf("列出ConfigMaps失败: %v", err)
+	}
+
+	deleted := 0
+	for i := range configMaps.Items {
+		cm := &configMaps.Items[i]
+		if !storage.IsStateObject(cm.Namespace, cm.Name) {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "删除端口状态: %s/%s\n", cm.Namespace, cm.Name)
+		deleted++
+		if dryRun {
+			continue
+		}
+		if err := c.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("删除ConfigMap %s/%s 失败: %v", cm.Namespace, cm.Name, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "已删除 %d 个端口状态ConfigMap\n", deleted)
+	return nil
+}
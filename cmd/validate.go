@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/simulate"
+)
+
+// runValidate 检查配置文件，用于在 CI 中校验配置变更
+func runValidate(args []string) error {
+	var configFile, nodePortRange, output string
+	var strict bool
+	fs := newCommandFlags("validate", &configFile)
+	fs.StringVar(&nodePortRange, "service-node-port-range", "", "kube-apiserver 的 --service-node-port-range，默认使用配置中的 nodePortRange")
+	fs.StringVar(&output, "output", "table", "输出格式 table 或 json")
+	fs.BoolVar(&strict, "strict", false, "将警告视为错误")
+	_ = fs.Parse(args)
+
+	cfg, err := config.ReadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	clusterRange := cfg.ClusterNodePortRange
+	if nodePortRange != "" {
+		if clusterRange, err = config.ParseNodePortRange(nodePortRange); err != nil {
+			return err
+		}
+	}
+
+	findings := config.Check(cfg, clusterRange)
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(findings); err != nil {
+			return err
+		}
+	} else {
+		for _, finding := range findings {
+			fmt.Printf("[%s] %s\n", finding.Severity, finding.Message)
+		}
+	}
+
+	errorCount := 0
+	for _, finding := range findings {
+		if finding.Severity == config.SeverityError || strict {
+			errorCount++
+		}
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("配置检查未通过，共 %d 个问题", errorCount)
+	}
+
+	if output != "json" {
+		fmt.Printf("配置检查通过（%d 个警告）\n", len(findings))
+	}
+	return nil
+}
+
+// runSimulate 根据导出的 Namespaces 与 Services 模拟端口范围映射与分配
+func runSimulate(args []string) error {
+	var configFile, input, output string
+	fs := newCommandFlags("simulate", &configFile)
+	fs.StringVar(&input, "input", "-", "kubectl get namespaces,services -A -o json 的输出，- 表示标准输入")
+	fs.StringVar(&output, "output", "table", "输出格式 table 或 json")
+	_ = fs.Parse(args)
+
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	r, err := openInput(input)
+	if err != nil {
+		return fmt.Errorf("打开输入文件失败: %v", err)
+	}
+	defer r.Close()
+
+	dump, err := simulate.ReadDump(r)
+	if err != nil {
+		return err
+	}
+
+	result, err := simulate.Run(cfg, dump)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	return result.WriteTable(os.Stdout)
+}
@@ -0,0 +1,114 @@
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// 审计注解键，apiserver 写入审计日志时会加上 Webhook 名称作为前缀，
+// 如 mutate.nodeport-allocator.example.com/range
+const (
+	// AuditKeyDecision 准入结果：allowed 或 denied
+	AuditKeyDecision = "decision"
+	// AuditKeyReason 作出该结果的原因
+	AuditKeyReason = "reason"
+	// AuditKeyRange Service 使用的端口范围
+	AuditKeyRange = "range"
+	// AuditKeyPreviousRange 更新前 Service 使用的端口范围，仅在应属范围变化时记录
+	AuditKeyPreviousRange = "previous-range"
+	// AuditKeyPorts Service 的全部 NodePort，格式为 <端口名>=<NodePort>
+	AuditKeyPorts = "ports"
+	// AuditKeyAllocatedPorts 本次新分配的 NodePort
+	AuditKeyAllocatedPorts = "allocated-ports"
+	// AuditKeyStrategy 分配策略
+	AuditKeyStrategy = "strategy"
+)
+
+// 准入结果
+const (
+	DecisionAllowed = "allowed"
+	DecisionDenied  = "denied"
+)
+
+// 审计原因；拒绝原因为 *portmanager.AllocationError 时直接使用其 Reason
+const (
+	// AuditReasonAllocated 本次为 Service 分配了新端口
+	AuditReasonAllocated = "Allocated"
+	// AuditReasonAlreadyOwned Service 的端口均已属于该 Service，无需分配
+	AuditReasonAlreadyOwned = "AlreadyOwned"
+	// AuditReasonNotNodePort 非 NodePort 类型的 Service 不做处理
+	AuditReasonNotNodePort = "NotNodePort"
+	// AuditReasonLeaderUnavailableIgnored Leader 不可达，按 failurePolicy 放行并由 apiserver 分配
+	AuditReasonLeaderUnavailableIgnored = "LeaderUnavailableIgnored"
+	// AuditReasonStorageUnavailableIgnored 存储不可用，按 failurePolicy 放行并由 apiserver 分配
+	AuditReasonStorageUnavailableIgnored = "StorageUnavailableIgnored"
+	// AuditReasonPendingRegistration Service 的端口尚待登记，更新时不重新分配
+	AuditReasonPendingRegistration = "PendingRegistration"
+	// AuditReasonValidated 最终对象通过校验
+	AuditReasonValidated = "Validated"
+	// AuditReasonInvalidObject 请求中的对象无法解析
+	AuditReasonInvalidObject = "InvalidObject"
+	// AuditReasonRehomeDenied 按 rehomePolicy=deny 拒绝改变端口范围的更新
+	AuditReasonRehomeDenied = "RehomeDenied"
+	// AuditReasonLeaderUnavailable Leader 不可达
+	AuditReasonLeaderUnavailable = "LeaderUnavailable"
+	// AuditReasonError 其他错误
+	AuditReasonError = "Error"
+)
+
+// auditDecision 生成记录准入结果与原因的审计注解
+func auditDecision(decision, reason string) map[string]string {
+	return map[string]string{
+		AuditKeyDecision: decision,
+		AuditKeyReason:   reason,
+	}
+}
+
+// auditDenial 生成拒绝请求时的审计注解，原因取自错误类型
+func auditDenial(err error) map[string]string {
+	reason := AuditReasonError
+	var denial *portmanager.AllocationError
+	switch {
+	case errors.As(err, &denial):
+		reason = string(denial.Reason)
+	case errors.Is(err, portmanager.ErrLeaderUnavailable):
+		reason = AuditReasonLeaderUnavailable
+	}
+	return auditDecision(DecisionDenied, reason)
+}
+
+// audit 合并审计注解
+func (m *ServiceMutation) audit(annotations map[string]string) {
+	if m.Audit == nil {
+		m.Audit = make(map[string]string, len(annotations))
+	}
+	for key, value := range annotations {
+		m.Audit[key] = value
+	}
+}
+
+// auditAllocation 记录允许请求时的分配结果
+func (m *ServiceMutation) auditAllocation(results []portmanager.AllocationResult) {
+	var ports, allocated []string
+	for _, result := range results {
+		entry := fmt.Sprintf("%s=%d", result.PortName, result.AllocatedPort)
+		ports = append(ports, entry)
+		if result.Allocated {
+			allocated = append(allocated, entry)
+		}
+	}
+
+	annotations := auditDecision(DecisionAllowed, AuditReasonAlreadyOwned)
+	if len(allocated) > 0 {
+		annotations[AuditKeyReason] = AuditReasonAllocated
+		annotations[AuditKeyAllocatedPorts] = strings.Join(allocated, ",")
+	}
+	if len(results) > 0 {
+		annotations[AuditKeyPorts] = strings.Join(ports, ",")
+		annotations[AuditKeyStrategy] = portmanager.ResultsStrategy(results)
+	}
+	m.audit(annotations)
+}
@@ -0,0 +1,36 @@
+package admission
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+)
+
+// withRequestLanguage 返回携带请求语言的 context：命名空间通过注解指定了支持的语言时使用该语言，
+// 否则使用配置的默认语言；读取命名空间失败不影响请求处理
+func withRequestLanguage(ctx context.Context, reader client.Reader, namespace string, logger logr.Logger) context.Context {
+	if reader == nil || namespace == "" {
+		return ctx
+	}
+
+	var ns corev1.Namespace
+	if err := reader.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		logger.V(1).Info("读取命名空间失败，使用默认语言", "error", err.Error())
+		return ctx
+	}
+
+	value, exists := ns.Annotations[i18n.AnnotationLanguage]
+	if !exists {
+		return ctx
+	}
+	lang, ok := i18n.Parse(value)
+	if !ok {
+		logger.V(1).Info("命名空间指定了不支持的语言，使用默认语言", "language", value)
+		return ctx
+	}
+	return i18n.WithLanguage(ctx, lang)
+}
@@ -2,34 +2,43 @@ package admission
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
 	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+	"github.com/tiggoins/nodeport-allocator/pkg/webhook"
 )
 
 // Mutator MutatingAdmissionWebhook实现
 type Mutator struct {
 	portManager *portmanager.Manager
+	reader      client.Reader
 	logger      logr.Logger
 	decoder     runtime.Decoder
 }
 
 // NewMutator 创建新的变更器
-func NewMutator(portManager *portmanager.Manager, logger logr.Logger) *Mutator {
+func NewMutator(portManager *portmanager.Manager, reader client.Reader, logger logr.Logger) *Mutator {
 	scheme := runtime.NewScheme()
 	_ = corev1.AddToScheme(scheme)
 	_ = admissionv1.AddToScheme(scheme)
 
 	return &Mutator{
 		portManager: portManager,
+		reader:      reader,
 		logger:      logger,
 		decoder:     serializer.NewCodecFactory(scheme).UniversalDeserializer(),
 	}
@@ -45,34 +54,51 @@ func (m *Mutator) Handle(ctx context.Context, req *admissionv1.AdmissionRequest)
 		logger.Info("跳过非Service资源")
 		return NewAdmissionResponse(req.UID).Allow().AdmissionResponse
 	}
+	ctx = withRequestLanguage(ctx, m.reader, req.Namespace, logger)
 
 	// 解析Service对象
 	var service corev1.Service
 	if err := runtime.DecodeInto(m.decoder, req.Object.Raw, &service); err != nil {
 		logger.Error(err, "解析Service对象失败")
-		return NewAdmissionResponse(req.UID).Deny(fmt.Sprintf("解析Service对象失败: %v", err)).AdmissionResponse
+		return NewAdmissionResponse(req.UID).Deny(i18n.T(ctx, i18n.DecodeServiceFailed, err)).
+			WithAuditAnnotations(auditDecision(DecisionDenied, AuditReasonInvalidObject)).AdmissionResponse
 	}
 
 	// 只处理NodePort类型的Service
 	if service.Spec.Type != corev1.ServiceTypeNodePort {
 		logger.Info("跳过非NodePort类型的Service", "type", service.Spec.Type)
-		return NewAdmissionResponse(req.UID).Allow().AdmissionResponse
+		return NewAdmissionResponse(req.UID).Allow().
+			WithAuditAnnotations(auditDecision(DecisionAllowed, AuditReasonNotNodePort)).AdmissionResponse
+	}
+
+	// 更新时解析旧对象，用于判断 Service 应属的端口范围是否变化
+	var oldService *corev1.Service
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		oldService = &corev1.Service{}
+		if err := runtime.DecodeInto(m.decoder, req.OldObject.Raw, oldService); err != nil {
+			logger.Error(err, "解析旧Service对象失败")
+			return NewAdmissionResponse(req.UID).Deny(i18n.T(ctx, i18n.DecodeOldServiceFailed, err)).
+				WithAuditAnnotations(auditDecision(DecisionDenied, AuditReasonInvalidObject)).AdmissionResponse
+		}
 	}
 
 	// 处理端口分配和验证
-	mutation, err := m.processService(ctx, &service, req.Operation)
+	mutation, err := m.processService(ctx, &service, oldService, req.Operation, isDryRun(req))
 	if err != nil {
 		logger.Error(err, "处理Service失败")
-		return NewAdmissionResponse(req.UID).Deny(err.Error()).AdmissionResponse
+		return NewAdmissionResponse(req.UID).DenyError(err, service.Name).WithAuditAnnotations(auditDenial(err)).AdmissionResponse
 	}
 
 	if !mutation.Allowed {
 		logger.Info("Service被拒绝", "reason", mutation.Message)
-		return NewAdmissionResponse(req.UID).Deny(mutation.Message).AdmissionResponse
+		if mutation.Err != nil {
+			return NewAdmissionResponse(req.UID).DenyError(mutation.Err, service.Name).WithAuditAnnotations(mutation.Audit).AdmissionResponse
+		}
+		return NewAdmissionResponse(req.UID).Deny(mutation.Message).WithAuditAnnotations(mutation.Audit).AdmissionResponse
 	}
 
 	// 构建响应
-	response := NewAdmissionResponse(req.UID).Allow()
+	response := NewAdmissionResponse(req.UID).Allow().WithAuditAnnotations(mutation.Audit)
 
 	if len(mutation.Patches) > 0 {
 		response.WithPatches(mutation.Patches)
@@ -89,42 +115,87 @@ func (m *Mutator) Handle(ctx context.Context, req *admissionv1.AdmissionRequest)
 }
 
 // processService 处理Service的端口分配和验证
-func (m *Mutator) processService(ctx context.Context, service *corev1.Service, operation admissionv1.Operation) (*ServiceMutation, error) {
+// oldService 为更新前的对象，创建时为 nil；dryRun 时只基于缓存预选端口，不写入端口状态
+func (m *Mutator) processService(ctx context.Context, service *corev1.Service, oldService *corev1.Service, operation admissionv1.Operation, dryRun bool) (*ServiceMutation, error) {
 	mutation := &ServiceMutation{
 		Service: service,
 		Allowed: true,
+		DryRun:  dryRun,
 	}
 
-	// 检查是否有需要分配的端口
-	needsAllocation := false
-	for _, port := range service.Spec.Ports {
-		if port.NodePort == 0 {
-			needsAllocation = true
-			break
+	// 创建和更新都按所属关系登记端口：已属于该 Service 的端口保持不变，
+	// 新指定的端口和未设置的端口在此时分配，使所属记录与 Service 保持一致
+	if operation == admissionv1.Create || operation == admissionv1.Update {
+		// 端口仍待控制器登记时不重新分配（apiserver 分配的端口可能不在应属范围内），
+		// 只按更新前的对象判断，避免创建时通过设置注解跳过分配
+		if oldService != nil && pendingRegistration(m.portManager.GetConfig(), oldService, nil) {
+			mutation.audit(auditDecision(DecisionAllowed, AuditReasonPendingRegistration))
+			return mutation, nil
 		}
-	}
-
-	if needsAllocation || operation == admissionv1.Create {
-		return m.handlePortAllocation(ctx, mutation)
-	}
 
-	if operation == admissionv1.Update {
-		return m.handlePortValidation(ctx, mutation)
+		rangeName, err := m.resolveRange(ctx, mutation, oldService)
+		if err != nil {
+			return nil, err
+		}
+		if !mutation.Allowed {
+			return mutation, nil
+		}
+		mutation.audit(map[string]string{AuditKeyRange: rangeName})
+		return m.handlePortAllocation(ctx, mutation, rangeName)
 	}
 
 	return mutation, nil
 }
 
-// handlePortAllocation 处理端口分配
-func (m *Mutator) handlePortAllocation(ctx context.Context, mutation *ServiceMutation) (*ServiceMutation, error) {
+// markPendingRegistration 放行未分配端口的请求，添加待登记注解，由控制器在恢复后登记 apiserver 分配的端口
+func markPendingRegistration(mutation *ServiceMutation, reason string) *ServiceMutation {
+	mutation.Patches = append(mutation.Patches, annotationPatches(mutation.Service,
+		map[string]string{portmanager.AnnotationPendingRegistration: "true"})...)
+	mutation.audit(auditDecision(DecisionAllowed, reason))
+	return mutation
+}
+
+// handlePortAllocation 在 rangeName 指定的端口范围中处理端口分配
+func (m *Mutator) handlePortAllocation(ctx context.Context, mutation *ServiceMutation, rangeName string) (*ServiceMutation, error) {
 	allocator := m.portManager.GetAllocator()
 
-	results, err := allocator.AllocateForService(ctx, mutation.Service)
+	allocate := allocator.AllocateForService
+	if mutation.DryRun {
+		allocate = allocator.PreviewForService
+	}
+	results, err := allocate(ctx, mutation.Service, rangeName)
 	if err != nil {
+		if errors.Is(err, portmanager.ErrLeaderUnavailable) &&
+			m.portManager.GetConfig().HighAvailability.LeaderForward.FailurePolicy == config.FailurePolicyIgnore {
+			// 按配置放行，由 apiserver 自行分配 NodePort，并标记 Service 待控制器在 Leader 恢复后登记
+			m.logger.Error(err, "Leader不可达，按failurePolicy放行请求",
+				"service", fmt.Sprintf("%s/%s", mutation.Service.Namespace, mutation.Service.Name))
+			mutation.Warnings = append(mutation.Warnings, i18n.T(ctx, i18n.LeaderUnavailableAllowed, err))
+			return markPendingRegistration(mutation, AuditReasonLeaderUnavailableIgnored), nil
+		}
+		if errors.Is(err, portmanager.ErrStorageUnavailable) &&
+			m.portManager.GetConfig().StorageConfig.FailurePolicy == config.FailurePolicyIgnore {
+			// 降级模式：由 apiserver 分配 NodePort，并标记 Service 待控制器在存储恢复后登记
+			m.logger.Error(err, "存储不可用，按failurePolicy放行请求",
+				"service", fmt.Sprintf("%s/%s", mutation.Service.Namespace, mutation.Service.Name))
+			mutation.Warnings = append(mutation.Warnings, i18n.T(ctx, i18n.StorageUnavailableAllowed, err))
+			return markPendingRegistration(mutation, AuditReasonStorageUnavailableIgnored), nil
+		}
 		mutation.Allowed = false
 		mutation.Message = err.Error()
+		mutation.Err = err
+		mutation.audit(auditDenial(err))
 		return mutation, nil
 	}
+	mutation.auditAllocation(results)
+
+	// 端口已登记，不再需要（可能由用户设置的）待登记标记
+	if portmanager.PendingRegistration(mutation.Service) {
+		mutation.Patches = append(mutation.Patches, MutationPatch{
+			Op:   "remove",
+			Path: "/metadata/annotations/" + escapeJSONPointer(portmanager.AnnotationPendingRegistration),
+		})
+	}
 
 	// 生成补丁和警告信息
 	for _, result := range results {
@@ -138,8 +209,32 @@ func (m *Mutator) handlePortAllocation(ctx context.Context, mutation *ServiceMut
 			mutation.Patches = append(mutation.Patches, patch)
 		}
 
-		// 添加警告信息
-		mutation.Warnings = append(mutation.Warnings, result.Message)
+		// 添加警告信息（已属于该 Service 的端口不再重复提示）
+		if result.Allocated {
+			mutation.Warnings = append(mutation.Warnings, result.Message)
+		}
+	}
+
+	// 在Service上记录分配元数据，仅在有新分配或记录的范围变化时更新
+	annotations := make(map[string]string)
+	if len(results) > 0 && (hasAllocated(results) || mutation.Service.Annotations[portmanager.AnnotationRange] != rangeName) {
+		metadata, err := m.portManager.AllocationAnnotations(rangeName, results, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range metadata {
+			annotations[key] = value
+		}
+	}
+	// 标记新分配的端口，由控制器在 Service 持久化后记录分配事件；之前的分配尚未记录时合并
+	if allocated := portmanager.FormatAllocated(results); allocated != "" {
+		if previous := mutation.Service.Annotations[portmanager.AnnotationUnrecordedAllocation]; previous != "" {
+			allocated = previous + ", " + allocated
+		}
+		annotations[portmanager.AnnotationUnrecordedAllocation] = allocated
+	}
+	if len(annotations) > 0 {
+		mutation.Patches = append(mutation.Patches, annotationPatches(mutation.Service, annotations)...)
 	}
 
 	m.logger.Info("端口分配完成",
@@ -149,67 +244,54 @@ func (m *Mutator) handlePortAllocation(ctx context.Context, mutation *ServiceMut
 	return mutation, nil
 }
 
-// handlePortValidation 处理端口验证
-func (m *Mutator) handlePortValidation(ctx context.Context, mutation *ServiceMutation) (*ServiceMutation, error) {
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
-	}
-	
-	namespace := mutation.Service.Namespace
-	if namespace == "" {
-		namespace = "default"
-	}
-
-	// 验证所有指定的端口
-	for _, port := range mutation.Service.Spec.Ports {
-		if port.NodePort != 0 {
-			if err := m.portManager.ValidatePortForService(namespace, mutation.Service.Labels, port.NodePort); err != nil {
-				mutation.Allowed = false
-				mutation.Message = fmt.Sprintf("端口 %d 验证失败: %v", port.NodePort, err)
-				return mutation, nil
-			}
-		}
-	}
-
-	return mutation, nil
+// isDryRun 判断准入请求是否为 dryRun，dryRun 请求不能产生持久化的副作用
+func isDryRun(req *admissionv1.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
 }
 
-// ServeHTTP 实现http.Handler接口
-func (m *Mutator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
-		return
+// hasAllocated 判断分配结果中是否有本次新分配的端口
+func hasAllocated(results []portmanager.AllocationResult) bool {
+	for _, result := range results {
+		if result.Allocated {
+			return true
+		}
 	}
+	return false
+}
 
-	if r.Header.Get("Content-Type") != "application/json" {
-		http.Error(w, "Content-Type必须为application/json", http.StatusBadRequest)
-		return
+// annotationPatches 生成设置注解的补丁，Service 尚无注解时整体添加
+func annotationPatches(service *corev1.Service, annotations map[string]string) []MutationPatch {
+	if len(service.Annotations) == 0 {
+		return []MutationPatch{{
+			Op:    "add",
+			Path:  "/metadata/annotations",
+			Value: annotations,
+		}}
 	}
 
-	var admissionReview admissionv1.AdmissionReview
-	if err := json.NewDecoder(r.Body).Decode(&admissionReview); err != nil {
-		m.logger.Error(err, "解析AdmissionReview失败")
-		http.Error(w, fmt.Sprintf("解析请求失败: %v", err), http.StatusBadRequest)
-		return
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
 	}
-
-	if admissionReview.Request == nil {
-		http.Error(w, "AdmissionRequest为空", http.StatusBadRequest)
-		return
+	sort.Strings(keys)
+
+	patches := make([]MutationPatch, 0, len(keys))
+	for _, key := range keys {
+		patches = append(patches, MutationPatch{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + escapeJSONPointer(key),
+			Value: annotations[key],
+		})
 	}
+	return patches
+}
 
-	// 处理准入请求
-	response := m.Handle(r.Context(), admissionReview.Request)
-
-	// 构建响应
-	admissionReview.Response = response
-	admissionReview.Response.UID = admissionReview.Request.UID
+// escapeJSONPointer 按 RFC 6901 转义 JSON Pointer 中的路径片段
+func escapeJSONPointer(segment string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(segment, "~", "~0"), "/", "~1")
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(admissionReview); err != nil {
-		m.logger.Error(err, "编码响应失败")
-		http.Error(w, fmt.Sprintf("编码响应失败: %v", err), http.StatusInternalServerError)
-	}
+// ServeHTTP 实现http.Handler接口，请求解析与版本协商与 webhook.AdmissionHandler 一致
+func (m *Mutator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(&webhook.AdmissionHandler{Handler: m, Logger: m.logger}).ServeHTTP(w, r)
 }
@@ -0,0 +1,103 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// resolveRange 确定本次分配使用的端口范围
+// 更新导致 Service 应属的范围变化（如修改标签）时按 rehomePolicy 处理：
+// keep 继续使用原范围；reallocate 使用新范围，并将沿用的、不属于新范围的端口置空以便重新分配；
+// deny 拒绝该更新（设置 mutation.Allowed 为 false）
+func (m *Mutator) resolveRange(ctx context.Context, mutation *ServiceMutation, oldService *corev1.Service) (string, error) {
+	cfg := m.portManager.GetConfig()
+	service := mutation.Service
+
+	namespace := service.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	rangeName, portRange, err := cfg.GetPortRangeForService(namespace, service.Labels)
+	if err != nil {
+		return "", i18n.Errorf(ctx, i18n.RangeLookupFailed, namespace, service.Name, err)
+	}
+
+	if oldService == nil || oldService.Spec.Type != corev1.ServiceTypeNodePort {
+		return rangeName, nil
+	}
+
+	oldRange, err := previousRange(ctx, cfg, namespace, oldService)
+	if err != nil {
+		return "", err
+	}
+	if oldRange == rangeName {
+		return rangeName, nil
+	}
+	mutation.audit(map[string]string{AuditKeyPreviousRange: oldRange})
+
+	switch cfg.RehomePolicy {
+	case config.RehomePolicyDeny:
+		mutation.Allowed = false
+		mutation.Message = i18n.T(ctx, i18n.RehomeDenied, oldRange, rangeName, cfg.RehomePolicy)
+		audit := auditDecision(DecisionDenied, AuditReasonRehomeDenied)
+		audit[AuditKeyRange] = rangeName
+		mutation.audit(audit)
+		return rangeName, nil
+
+	case config.RehomePolicyReallocate:
+		previous := make(map[int32]bool)
+		for _, port := range oldService.Spec.Ports {
+			previous[port.NodePort] = true
+		}
+
+		// 只重新分配沿用旧值的端口，本次更新中显式指定的新端口按新范围校验
+		var moved []string
+		for i := range service.Spec.Ports {
+			port := &service.Spec.Ports[i]
+			if port.NodePort == 0 || !previous[port.NodePort] {
+				continue
+			}
+			if port.NodePort < portRange.Start || port.NodePort > portRange.End {
+				moved = append(moved, fmt.Sprintf("%d", port.NodePort))
+				port.NodePort = 0
+			}
+		}
+
+		if len(moved) == 0 {
+			mutation.Warnings = append(mutation.Warnings, i18n.T(ctx, i18n.RehomeNothingToMove, oldRange, rangeName))
+			return rangeName, nil
+		}
+		mutation.Warnings = append(mutation.Warnings, i18n.T(ctx, i18n.RehomeReallocated,
+			oldRange, rangeName, cfg.RehomePolicy, strings.Join(moved, ", ")))
+		return rangeName, nil
+
+	default:
+		mutation.Warnings = append(mutation.Warnings, i18n.T(ctx, i18n.RehomeKept,
+			rangeName, cfg.RehomePolicy, oldRange))
+		return oldRange, nil
+	}
+}
+
+// previousRange 返回更新前 Service 使用的端口范围：优先使用分配时记录的注解，
+// 未记录或该范围已从配置中删除时按旧对象的标签匹配
+func previousRange(ctx context.Context, cfg *config.Config, namespace string, oldService *corev1.Service) (string, error) {
+	if recorded := oldService.Annotations[portmanager.AnnotationRange]; recorded != "" {
+		if _, exists := cfg.PortRanges[recorded]; exists {
+			return recorded, nil
+		}
+	}
+
+	rangeName, _, err := cfg.GetPortRangeForService(namespace, oldService.Labels)
+	if err != nil {
+		return "", i18n.Errorf(ctx, i18n.PreviousRangeFailed, namespace, oldService.Name, err)
+	}
+	return rangeName, nil
+}
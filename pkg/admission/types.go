@@ -2,11 +2,15 @@ package admission
 
 import (
 	"encoding/json"
+	"errors"
+	"net/http"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
 )
 
 
@@ -28,7 +32,13 @@ type ServiceMutation struct {
     Patches     []MutationPatch
     Warnings    []string
     Message     string
+    // Err 拒绝的原因，为 *portmanager.AllocationError 时返回结构化的拒绝信息
+    Err         error
     Allowed     bool
+    // DryRun dryRun 请求只计算补丁，不写入端口状态
+    DryRun      bool
+    // Audit 写入 apiserver 审计日志的注解，记录端口范围、分配结果与准入原因
+    Audit       map[string]string
 }
 
 // NewAdmissionResponse 创建准入响应
@@ -58,6 +68,67 @@ func (r *AdmissionResponse) Deny(message string) *AdmissionResponse {
     return r
 }
 
+// DenyError 按错误类型拒绝请求：*portmanager.AllocationError 转换为对应的 Status 原因、状态码，
+// 并在 Details.Causes 中给出出错字段；Leader 不可达时返回可重试的 503；其他错误按 Deny 处理
+func (r *AdmissionResponse) DenyError(err error, name string) *AdmissionResponse {
+    var denial *portmanager.AllocationError
+    switch {
+    case errors.As(err, &denial):
+        reason, code := denialStatus(denial.Reason)
+        r.Allowed = false
+        r.Result = &metav1.Status{
+            Status:  metav1.StatusFailure,
+            Code:    code,
+            Reason:  reason,
+            Message: err.Error(),
+            Details: &metav1.StatusDetails{
+                Name: name,
+                Kind: "services",
+                Causes: []metav1.StatusCause{{
+                    Type:    metav1.CauseType("NodePort" + string(denial.Reason)),
+                    Message: denial.Message,
+                    Field:   denial.Field(),
+                }},
+            },
+        }
+        if denial.Reason == portmanager.ReasonStorageUnavailable {
+            r.Result.Details.RetryAfterSeconds = retryAfterSeconds
+        }
+        return r
+
+    case errors.Is(err, portmanager.ErrLeaderUnavailable):
+        r.Allowed = false
+        r.Result = &metav1.Status{
+            Status:  metav1.StatusFailure,
+            Code:    http.StatusServiceUnavailable,
+            Reason:  metav1.StatusReasonServiceUnavailable,
+            Message: err.Error(),
+            Details: &metav1.StatusDetails{Name: name, Kind: "services", RetryAfterSeconds: retryAfterSeconds},
+        }
+        return r
+    }
+
+    return r.Deny(err.Error())
+}
+
+// retryAfterSeconds 存储或 Leader 暂时不可用时建议的重试间隔
+const retryAfterSeconds = 5
+
+// denialStatus 拒绝原因对应的 Status 原因与 HTTP 状态码
+func denialStatus(reason portmanager.DenialReason) (metav1.StatusReason, int32) {
+    switch reason {
+    case portmanager.ReasonPortInUse:
+        return metav1.StatusReasonConflict, http.StatusConflict
+    case portmanager.ReasonOutsideRange:
+        return metav1.StatusReasonInvalid, http.StatusUnprocessableEntity
+    case portmanager.ReasonStorageUnavailable:
+        return metav1.StatusReasonServiceUnavailable, http.StatusServiceUnavailable
+    default:
+        // 范围已满与 ResourceQuota 超限一致，使用 Forbidden
+        return metav1.StatusReasonForbidden, http.StatusForbidden
+    }
+}
+
 // WithPatches 添加变更补丁
 func (r *AdmissionResponse) WithPatches(patches []MutationPatch) *AdmissionResponse {
     if len(patches) > 0 {
@@ -69,6 +140,14 @@ func (r *AdmissionResponse) WithPatches(patches []MutationPatch) *AdmissionRespo
     return r
 }
 
+// WithAuditAnnotations 添加审计注解
+func (r *AdmissionResponse) WithAuditAnnotations(annotations map[string]string) *AdmissionResponse {
+    if len(annotations) > 0 {
+        r.AuditAnnotations = annotations
+    }
+    return r
+}
+
 // WithWarnings 添加警告信息
 func (r *AdmissionResponse) WithWarnings(warnings []string) *AdmissionResponse {
     r.Warnings = warnings
@@ -0,0 +1,103 @@
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+func TestDenyErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantReason metav1.StatusReason
+		wantCode   int32
+		wantCauses []metav1.StatusCause
+		wantRetry  int32
+	}{
+		{
+			name:       "range exhausted",
+			err:        &portmanager.AllocationError{Reason: portmanager.ReasonRangeExhausted, PortIndex: 0, Message: "full"},
+			wantReason: metav1.StatusReasonForbidden,
+			wantCode:   http.StatusForbidden,
+			wantCauses: []metav1.StatusCause{{Type: "NodePortRangeExhausted", Message: "full", Field: "spec.ports[0].nodePort"}},
+		},
+		{
+			name:       "port in use",
+			err:        &portmanager.AllocationError{Reason: portmanager.ReasonPortInUse, PortIndex: 1, Port: 30001, Message: "in use"},
+			wantReason: metav1.StatusReasonConflict,
+			wantCode:   http.StatusConflict,
+			wantCauses: []metav1.StatusCause{{Type: "NodePortPortInUse", Message: "in use", Field: "spec.ports[1].nodePort"}},
+		},
+		{
+			name:       "outside range",
+			err:        &portmanager.AllocationError{Reason: portmanager.ReasonOutsideRange, PortIndex: 2, Port: 40000, Message: "outside"},
+			wantReason: metav1.StatusReasonInvalid,
+			wantCode:   http.StatusUnprocessableEntity,
+			wantCauses: []metav1.StatusCause{{Type: "NodePortOutsideRange", Message: "outside", Field: "spec.ports[2].nodePort"}},
+		},
+		{
+			name:       "storage unavailable",
+			err:        &portmanager.AllocationError{Reason: portmanager.ReasonStorageUnavailable, PortIndex: -1, Message: "unavailable"},
+			wantReason: metav1.StatusReasonServiceUnavailable,
+			wantCode:   http.StatusServiceUnavailable,
+			wantCauses: []metav1.StatusCause{{Type: "NodePortStorageUnavailable", Message: "unavailable", Field: "spec.ports"}},
+			wantRetry:  retryAfterSeconds,
+		},
+		{
+			name:       "wrapped allocation error",
+			err:        fmt.Errorf("context: %w", &portmanager.AllocationError{Reason: portmanager.ReasonPortInUse, PortIndex: 0, Message: "in use"}),
+			wantReason: metav1.StatusReasonConflict,
+			wantCode:   http.StatusConflict,
+			wantCauses: []metav1.StatusCause{{Type: "NodePortPortInUse", Message: "in use", Field: "spec.ports[0].nodePort"}},
+		},
+		{
+			name:       "leader unavailable",
+			err:        fmt.Errorf("forward: %w", portmanager.ErrLeaderUnavailable),
+			wantReason: metav1.StatusReasonServiceUnavailable,
+			wantCode:   http.StatusServiceUnavailable,
+			wantRetry:  retryAfterSeconds,
+		},
+		{
+			name:     "other error",
+			err:      errors.New("boom"),
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := NewAdmissionResponse("uid").DenyError(tt.err, "web")
+			if response.Allowed {
+				t.Fatal("Allowed = true")
+			}
+			status := response.Result
+			if status.Reason != tt.wantReason || status.Code != tt.wantCode {
+				t.Errorf("status = %s %d, want %s %d", status.Reason, status.Code, tt.wantReason, tt.wantCode)
+			}
+			if status.Message != tt.err.Error() {
+				t.Errorf("message = %q, want %q", status.Message, tt.err.Error())
+			}
+
+			var causes []metav1.StatusCause
+			var retry int32
+			if status.Details != nil {
+				causes, retry = status.Details.Causes, status.Details.RetryAfterSeconds
+				if status.Details.Name != "web" || status.Details.Kind != "services" {
+					t.Errorf("details = %s %s, want services web", status.Details.Kind, status.Details.Name)
+				}
+			}
+			if !reflect.DeepEqual(causes, tt.wantCauses) {
+				t.Errorf("causes = %+v, want %+v", causes, tt.wantCauses)
+			}
+			if retry != tt.wantRetry {
+				t.Errorf("retryAfterSeconds = %d, want %d", retry, tt.wantRetry)
+			}
+		})
+	}
+}
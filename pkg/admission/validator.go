@@ -0,0 +1,205 @@
+package admission
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// Validator ValidatingAdmissionWebhook实现
+// 变更 Webhook 之后的其他变更 Webhook 仍可能修改 nodePort，校验 Webhook 对最终对象重新检查：
+// 端口在 Service 应属的范围内，且已由分配器登记为该 Service 所有（未被其他 Service 占用）
+type Validator struct {
+	portManager *portmanager.Manager
+	reader      client.Reader
+	logger      logr.Logger
+	decoder     runtime.Decoder
+}
+
+// NewValidator 创建新的校验器
+func NewValidator(portManager *portmanager.Manager, reader client.Reader, logger logr.Logger) *Validator {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = admissionv1.AddToScheme(scheme)
+
+	return &Validator{
+		portManager: portManager,
+		reader:      reader,
+		logger:      logger,
+		decoder:     serializer.NewCodecFactory(scheme).UniversalDeserializer(),
+	}
+}
+
+// Handle 处理准入请求
+func (v *Validator) Handle(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	logger := v.logger.WithValues("uid", req.UID, "kind", req.Kind, "namespace", req.Namespace, "name", req.Name)
+
+	if req.Kind.Kind != "Service" || req.Kind.Version != "v1" {
+		return NewAdmissionResponse(req.UID).Allow().AdmissionResponse
+	}
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return NewAdmissionResponse(req.UID).Allow().AdmissionResponse
+	}
+	ctx = withRequestLanguage(ctx, v.reader, req.Namespace, logger)
+
+	var service corev1.Service
+	if err := runtime.DecodeInto(v.decoder, req.Object.Raw, &service); err != nil {
+		logger.Error(err, "解析Service对象失败")
+		return NewAdmissionResponse(req.UID).Deny(i18n.T(ctx, i18n.DecodeServiceFailed, err)).
+			WithAuditAnnotations(auditDecision(DecisionDenied, AuditReasonInvalidObject)).AdmissionResponse
+	}
+	if service.Spec.Type != corev1.ServiceTypeNodePort {
+		return NewAdmissionResponse(req.UID).Allow().AdmissionResponse
+	}
+	if service.Namespace == "" {
+		service.Namespace = req.Namespace
+	}
+
+	var oldService *corev1.Service
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		oldService = &corev1.Service{}
+		if err := runtime.DecodeInto(v.decoder, req.OldObject.Raw, oldService); err != nil {
+			logger.Error(err, "解析旧Service对象失败")
+			return NewAdmissionResponse(req.UID).Deny(i18n.T(ctx, i18n.DecodeOldServiceFailed, err)).
+				WithAuditAnnotations(auditDecision(DecisionDenied, AuditReasonInvalidObject)).AdmissionResponse
+		}
+	}
+
+	if err := v.validateService(ctx, &service, oldService, isDryRun(req)); err != nil {
+		logger.Info("Service未通过校验", "reason", err.Error())
+		return NewAdmissionResponse(req.UID).DenyError(err, service.Name).WithAuditAnnotations(auditDenial(err)).AdmissionResponse
+	}
+
+	logger.Info("Service通过校验")
+	return NewAdmissionResponse(req.UID).Allow().
+		WithAuditAnnotations(auditDecision(DecisionAllowed, AuditReasonValidated)).AdmissionResponse
+}
+
+// validateService 检查最终对象中的每个 NodePort，dryRun 时变更 Webhook 只预选了端口，未登记的空闲端口也可通过
+func (v *Validator) validateService(ctx context.Context, service *corev1.Service, oldService *corev1.Service, dryRun bool) error {
+	cfg := v.portManager.GetConfig()
+
+	// 存储或 Leader 不可用时放行的 Service，端口由 apiserver 分配，尚未登记
+	if pendingRegistration(cfg, service, oldService) {
+		return nil
+	}
+
+	rangeName, err := entitledRange(ctx, cfg, service, oldService)
+	if err != nil {
+		return err
+	}
+	portRange := cfg.PortRanges[rangeName]
+	owner := portmanager.OwnerKey(service)
+
+	for i, port := range service.Spec.Ports {
+		if port.NodePort == 0 {
+			return i18n.Errorf(ctx, i18n.NodePortUnassigned, port.Name)
+		}
+
+		// 已登记为该 Service 所有的端口无论位于哪个范围都允许保留
+		if (port.NodePort < portRange.Start || port.NodePort > portRange.End) && !v.ownedBy(ctx, port.NodePort, owner) {
+			if !cfg.AllowOutsideRangePorts {
+				return portError(portmanager.ReasonOutsideRange, i, port, i18n.T(ctx, i18n.OutsideEntitledRange,
+					port.NodePort, rangeName, portRange.Start, portRange.End))
+			}
+			if !cfg.ClusterNodePortRange.Contains(port.NodePort) {
+				return portError(portmanager.ReasonOutsideRange, i, port, i18n.T(ctx, i18n.OutsideClusterRange,
+					port.NodePort, cfg.ClusterNodePortRange))
+			}
+		}
+
+		if err := v.validateOwner(ctx, i, port, owner, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOwner 检查端口已登记为 owner 所有；缓存不一致时从存储刷新后再判断，
+// 避免其他副本刚完成的分配尚未同步到本副本而误拒绝
+func (v *Validator) validateOwner(ctx context.Context, index int, servicePort corev1.ServicePort, owner string, dryRun bool) error {
+	port := servicePort.NodePort
+	rangeName, current, err := v.portManager.PortOwner(ctx, port, false)
+	if err != nil {
+		return err
+	}
+	if rangeName == "" || current == owner {
+		// 不属于任何端口范围的端口（allowOutsideRangePorts）不由分配器登记
+		return nil
+	}
+
+	if _, current, err = v.portManager.PortOwner(ctx, port, true); err != nil {
+		if v.portManager.GetConfig().StorageConfig.FailurePolicy == config.FailurePolicyIgnore {
+			v.logger.Error(err, "存储不可用，按failurePolicy跳过端口所属检查", "port", port)
+			return nil
+		}
+		return portError(portmanager.ReasonStorageUnavailable, index, servicePort, i18n.T(ctx, i18n.OwnerReadFailed, port, err))
+	}
+	switch current {
+	case owner:
+		return nil
+	case "":
+		if dryRun {
+			return nil
+		}
+		return i18n.Errorf(ctx, i18n.PortNotRegistered, port)
+	default:
+		return portError(portmanager.ReasonPortInUse, index, servicePort, i18n.T(ctx, i18n.PortOwnedByOther, port, current))
+	}
+}
+
+// ownedBy 判断端口是否已登记为 owner 所有
+func (v *Validator) ownedBy(ctx context.Context, port int32, owner string) bool {
+	_, owned := v.portManager.PortOwnedBy(ctx, port, owner)
+	return owned
+}
+
+// portError 创建定位到 spec.ports 中第 index 个端口的拒绝错误
+func portError(reason portmanager.DenialReason, index int, port corev1.ServicePort, message string) error {
+	return &portmanager.AllocationError{
+		Reason:    reason,
+		PortIndex: index,
+		PortName:  port.Name,
+		Port:      port.NodePort,
+		Message:   message,
+	}
+}
+
+// entitledRange 返回 Service 应使用的端口范围，与变更 Webhook 的 resolveRange 一致：
+// 更新导致应属范围变化且 rehomePolicy 为 keep 时仍使用原范围
+func entitledRange(ctx context.Context, cfg *config.Config, service *corev1.Service, oldService *corev1.Service) (string, error) {
+	rangeName, _, err := cfg.GetPortRangeForService(service.Namespace, service.Labels)
+	if err != nil {
+		return "", i18n.Errorf(ctx, i18n.RangeLookupFailed, service.Namespace, service.Name, err)
+	}
+
+	if oldService == nil || oldService.Spec.Type != corev1.ServiceTypeNodePort || cfg.RehomePolicy != config.RehomePolicyKeep {
+		return rangeName, nil
+	}
+	return previousRange(ctx, cfg, service.Namespace, oldService)
+}
+
+// pendingRegistration 判断 Service 的端口是否尚待登记（存储或 Leader 不可用时按 failurePolicy=Ignore 放行）
+// 更新前后任一对象带有待登记注解即视为待登记，使控制器登记端口后移除注解的更新不被拒绝
+func pendingRegistration(cfg *config.Config, service *corev1.Service, oldService *corev1.Service) bool {
+	if !degradedAllowed(cfg) {
+		return false
+	}
+	return portmanager.PendingRegistration(service) || (oldService != nil && portmanager.PendingRegistration(oldService))
+}
+
+// degradedAllowed 判断是否配置了在存储或 Leader 不可用时放行请求
+func degradedAllowed(cfg *config.Config) bool {
+	leaderIgnore := cfg.HighAvailability.Mode == config.HAModeLeader &&
+		cfg.HighAvailability.LeaderForward.FailurePolicy == config.FailurePolicyIgnore
+	return leaderIgnore || cfg.StorageConfig.FailurePolicy == config.FailurePolicyIgnore
+}
@@ -0,0 +1,271 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// Kind 问题类型
+type Kind string
+
+const (
+	// KindSetButUnused 位图中已标记，但没有 Service 使用该端口
+	KindSetButUnused Kind = "SetButUnused"
+	// KindUsedButNotSet Service 使用了该端口，但所在范围的位图中未标记
+	KindUsedButNotSet Kind = "UsedButNotSet"
+	// KindOutsideRange Service 的端口不在其应属的端口范围内
+	KindOutsideRange Kind = "OutsideRange"
+	// KindDuplicate 多个 Service 使用同一端口
+	KindDuplicate Kind = "DuplicatePort"
+	// KindNoMatchingRange Service 未命中任何端口范围，只能回退到默认范围
+	KindNoMatchingRange Kind = "NoMatchingRange"
+	// KindOwnerMismatch 存储中记录的端口所属 Service 与集群中实际使用该端口的 Service 不一致
+	KindOwnerMismatch Kind = "OwnerMismatch"
+	// KindCorrupted 端口范围的状态数据已损坏，无法审计
+	KindCorrupted Kind = "CorruptedState"
+)
+
+// Finding 审计发现的问题
+type Finding struct {
+	Kind     Kind     `json:"kind"`
+	Range    string   `json:"range,omitempty"`
+	Port     int32    `json:"port,omitempty"`
+	Services []string `json:"services,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Fixable 是否可以通过修正位图与所属记录自动修复
+func (f Finding) Fixable() bool {
+	return f.Kind == KindSetButUnused || f.Kind == KindUsedButNotSet || f.Kind == KindOwnerMismatch
+}
+
+// Report 审计报告
+type Report struct {
+	Ranges   int       `json:"ranges"`
+	Services int       `json:"services"`
+	Findings []Finding `json:"findings"`
+}
+
+// Run 对比存储中的位图与集群中的 NodePort Services，生成审计报告
+func Run(ctx context.Context, storage *portmanager.Storage, reader client.Reader, cfg *config.Config) (*Report, error) {
+	var serviceList corev1.ServiceList
+	if err := reader.List(ctx, &serviceList); err != nil {
+		return nil, fmt.Errorf("列出Services失败: %v", err)
+	}
+
+	report := &Report{Ranges: len(cfg.PortRanges), Findings: []Finding{}}
+	users := make(map[int32][]string)
+
+	for _, service := range serviceList.Items {
+		if service.Spec.Type != corev1.ServiceTypeNodePort {
+			continue
+		}
+		report.Services++
+
+		namespace := service.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		serviceName := fmt.Sprintf("%s/%s", namespace, service.Name)
+
+		if _, matched := cfg.MatchPortRangeForService(namespace, service.Labels); !matched {
+			report.Findings = append(report.Findings, Finding{
+				Kind: KindNoMatchingRange, Range: cfg.DefaultRange, Services: []string{serviceName},
+				Message: "未命中任何端口范围，回退到默认范围",
+			})
+		}
+
+		rangeName, portRange, err := cfg.GetPortRangeForService(namespace, service.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[int32]bool)
+		for i, port := range service.Spec.Ports {
+			if port.NodePort == 0 || seen[port.NodePort] {
+				continue
+			}
+			seen[port.NodePort] = true
+			users[port.NodePort] = append(users[port.NodePort], serviceName)
+
+			if port.NodePort < portRange.Start || port.NodePort > portRange.End {
+				report.Findings = append(report.Findings, Finding{
+					Kind: KindOutsideRange, Range: rangeName, Port: port.NodePort, Services: []string{serviceName},
+					Message: fmt.Sprintf("spec.ports[%d].nodePort 不在端口范围 [%d, %d] 内", i, portRange.Start, portRange.End),
+				})
+			}
+		}
+	}
+
+	for _, port := range sortedPorts(users) {
+		if len(users[port]) > 1 {
+			report.Findings = append(report.Findings, Finding{
+				Kind: KindDuplicate, Port: port, Services: users[port],
+				Message: fmt.Sprintf("%d 个 Service 使用同一端口", len(users[port])),
+			})
+		}
+	}
+
+	for _, name := range sortedRangeNames(cfg) {
+		portRange := cfg.PortRanges[name]
+		state, err := storage.LoadState(ctx, name, portRange.Start, portRange.End)
+		if err != nil {
+			var corruption *portmanager.StateCorruptionError
+			if !errors.As(err, &corruption) {
+				return nil, fmt.Errorf("加载端口范围 %s 失败: %v", name, err)
+			}
+			report.Findings = append(report.Findings, Finding{Kind: KindCorrupted, Range: name, Message: err.Error()})
+			continue
+		}
+
+		for _, port := range state.BitSet.Ports() {
+			services, used := users[port]
+			switch {
+			case !used:
+				report.Findings = append(report.Findings, Finding{
+					Kind: KindSetButUnused, Range: name, Port: port,
+					Message: "位图中已标记，但没有 Service 使用该端口",
+				})
+			case len(services) == 1 && state.Owner(port) != services[0]:
+				owner := state.Owner(port)
+				if owner == "" {
+					owner = "无"
+				}
+				report.Findings = append(report.Findings, Finding{
+					Kind: KindOwnerMismatch, Range: name, Port: port, Services: services,
+					Message: fmt.Sprintf("存储中记录的所属 Service 为 %s", owner),
+				})
+			}
+		}
+
+		for _, port := range sortedPorts(users) {
+			if port >= portRange.Start && port <= portRange.End && !state.BitSet.Test(port) {
+				report.Findings = append(report.Findings, Finding{
+					Kind: KindUsedButNotSet, Range: name, Port: port, Services: users[port],
+					Message: "Service 使用了该端口，但位图中未标记",
+				})
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Fix 按报告修正位图与所属记录：清除无人使用的端口，标记已被使用的端口并记录所属 Service，
+// 更正不一致的所属记录，返回修正的端口数；其余类型的问题需要修改 Service 或配置，不会自动处理
+func Fix(ctx context.Context, storage *portmanager.Storage, cfg *config.Config, report *Report) (int, error) {
+	toSet := make(map[string]map[int32]string)
+	toClear := make(map[string][]int32)
+	for _, finding := range report.Findings {
+		switch finding.Kind {
+		case KindUsedButNotSet, KindOwnerMismatch:
+			if toSet[finding.Range] == nil {
+				toSet[finding.Range] = make(map[int32]string)
+			}
+			// 多个 Service 使用同一端口时无法确定所属，只标记端口
+			owner := ""
+			if len(finding.Services) == 1 {
+				owner = finding.Services[0]
+			}
+			toSet[finding.Range][finding.Port] = owner
+		case KindSetButUnused:
+			toClear[finding.Range] = append(toClear[finding.Range], finding.Port)
+		}
+	}
+
+	fixed := 0
+	for _, name := range sortedRangeNames(cfg) {
+		if len(toSet[name]) == 0 && len(toClear[name]) == 0 {
+			continue
+		}
+
+		portRange := cfg.PortRanges[name]
+		setPorts, clearPorts := toSet[name], toClear[name]
+		count := 0
+		_, err := storage.UpdateState(ctx, name, portRange.Start, portRange.End, func(state *portmanager.RangeState) (bool, error) {
+			count = 0
+			for port, owner := range setPorts {
+				if !state.BitSet.Test(port) || (owner != "" && state.Owner(port) != owner) {
+					if err := state.Set(port, owner); err != nil {
+						return false, err
+					}
+					count++
+				}
+			}
+			for _, port := range clearPorts {
+				if state.BitSet.Test(port) {
+					if err := state.Clear(port); err != nil {
+						return false, err
+					}
+					count++
+				}
+			}
+			return count > 0, nil
+		})
+		if err != nil {
+			return fixed, fmt.Errorf("修正端口范围 %s 失败: %v", name, err)
+		}
+		fixed += count
+	}
+
+	return fixed, nil
+}
+
+// WriteTable 以表格形式输出报告
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tRANGE\tPORT\tSERVICES\tMESSAGE")
+	for _, finding := range r.Findings {
+		port := "-"
+		if finding.Port != 0 {
+			port = fmt.Sprintf("%d", finding.Port)
+		}
+		services := "-"
+		if len(finding.Services) > 0 {
+			services = strings.Join(finding.Services, ",")
+		}
+		rangeName := finding.Range
+		if rangeName == "" {
+			rangeName = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", finding.Kind, rangeName, port, services, finding.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n共审计 %d 个端口范围、%d 个 NodePort Service，发现 %d 处问题\n",
+		r.Ranges, r.Services, len(r.Findings))
+	return err
+}
+
+// sortedPorts 排序后的端口列表
+func sortedPorts(users map[int32][]string) []int32 {
+	ports := make([]int32, 0, len(users))
+	for port := range users {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// sortedRangeNames 按名称排序的端口范围列表，保证输出稳定
+func sortedRangeNames(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.PortRanges))
+	for name := range cfg.PortRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// Export 导出所有配置的端口范围的已使用端口及其所属 Service
+// 优先使用存储中的所属记录，没有记录的端口根据集群中的 Services 标注
+func Export(ctx context.Context, storage *portmanager.Storage, reader client.Reader, cfg *config.Config) (*Snapshot, error) {
+	owners, err := liveOwners(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		APIVersion: SnapshotAPIVersion,
+		Kind:       SnapshotKind,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, name := range sortedRangeNames(cfg) {
+		portRange := cfg.PortRanges[name]
+		state, err := storage.LoadState(ctx, name, portRange.Start, portRange.End)
+		if err != nil {
+			return nil, fmt.Errorf("加载端口范围 %s 失败: %v", name, err)
+		}
+
+		rangeSnapshot := RangeSnapshot{Name: name, Start: portRange.Start, End: portRange.End, Ports: []PortRecord{}}
+		for _, port := range state.BitSet.Ports() {
+			owner := state.Owner(port)
+			if owner == "" {
+				owner = owners[port]
+			}
+			rangeSnapshot.Ports = append(rangeSnapshot.Ports, PortRecord{Port: port, Owner: owner})
+		}
+		snapshot.Ranges = append(snapshot.Ranges, rangeSnapshot)
+	}
+
+	return snapshot, nil
+}
+
+// liveOwners 列出集群中所有 NodePort Service 使用的端口及其所属 Service
+func liveOwners(ctx context.Context, reader client.Reader) (map[int32]string, error) {
+	var serviceList corev1.ServiceList
+	if err := reader.List(ctx, &serviceList); err != nil {
+		return nil, fmt.Errorf("列出Services失败: %v", err)
+	}
+
+	owners := make(map[int32]string)
+	for _, service := range serviceList.Items {
+		if service.Spec.Type != corev1.ServiceTypeNodePort {
+			continue
+		}
+		for _, port := range service.Spec.Ports {
+			if port.NodePort != 0 {
+				owners[port.NodePort] = fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+			}
+		}
+	}
+	return owners, nil
+}
+
+// sortedRangeNames 按名称排序的端口范围列表，保证输出稳定
+func sortedRangeNames(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.PortRanges))
+	for name := range cfg.PortRanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// 导入方式
+const (
+	// ImportModeMerge 在现有状态上追加快照中的端口
+	ImportModeMerge = "merge"
+	// ImportModeReplace 以快照内容覆盖现有状态
+	ImportModeReplace = "replace"
+)
+
+// RangeDiff 导入前后单个端口范围的差异
+type RangeDiff struct {
+	Range   string  `json:"range"`
+	Added   []int32 `json:"added,omitempty"`
+	Removed []int32 `json:"removed,omitempty"`
+}
+
+// Diff 计算以指定方式导入快照后各范围的变化，只包含有变化的范围
+func Diff(current, target *Snapshot, mode string) []RangeDiff {
+	var diffs []RangeDiff
+	for _, targetRange := range target.Ranges {
+		existing := make(map[int32]bool)
+		if currentRange := current.Range(targetRange.Name); currentRange != nil {
+			for _, record := range currentRange.Ports {
+				existing[record.Port] = true
+			}
+		}
+
+		diff := RangeDiff{Range: targetRange.Name}
+		wanted := make(map[int32]bool)
+		for _, record := range targetRange.Ports {
+			wanted[record.Port] = true
+			if !existing[record.Port] {
+				diff.Added = append(diff.Added, record.Port)
+			}
+		}
+		if mode == ImportModeReplace {
+			if currentRange := current.Range(targetRange.Name); currentRange != nil {
+				for _, record := range currentRange.Ports {
+					if !wanted[record.Port] {
+						diff.Removed = append(diff.Removed, record.Port)
+					}
+				}
+			}
+		}
+
+		if len(diff.Added) > 0 || len(diff.Removed) > 0 {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// CurrentState 读取存储中的当前状态用于导入前对比，已损坏的范围按空范围处理并返回其名称
+func CurrentState(ctx context.Context, storage *portmanager.Storage, cfg *config.Config) (*Snapshot, []string, error) {
+	snapshot := &Snapshot{APIVersion: SnapshotAPIVersion, Kind: SnapshotKind}
+	var corrupted []string
+
+	for _, name := range sortedRangeNames(cfg) {
+		portRange := cfg.PortRanges[name]
+		rangeSnapshot := RangeSnapshot{Name: name, Start: portRange.Start, End: portRange.End}
+
+		state, err := storage.LoadState(ctx, name, portRange.Start, portRange.End)
+		if err != nil {
+			var corruption *portmanager.StateCorruptionError
+			if !errors.As(err, &corruption) {
+				return nil, nil, fmt.Errorf("加载端口范围 %s 失败: %v", name, err)
+			}
+			corrupted = append(corrupted, name)
+		} else {
+			for _, port := range state.BitSet.Ports() {
+				rangeSnapshot.Ports = append(rangeSnapshot.Ports, PortRecord{Port: port, Owner: state.Owner(port)})
+			}
+		}
+		snapshot.Ranges = append(snapshot.Ranges, rangeSnapshot)
+	}
+
+	return snapshot, corrupted, nil
+}
+
+// Import 将快照写入存储（包括端口所属记录），快照中的范围必须存在于当前配置中
+// merge 方式下已有的所属记录不会被快照覆盖
+func Import(ctx context.Context, storage *portmanager.Storage, snapshot *Snapshot, cfg *config.Config, mode string) error {
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return fmt.Errorf("不支持的导入方式 %s，可选值: %s, %s", mode, ImportModeMerge, ImportModeReplace)
+	}
+
+	for _, rangeSnapshot := range snapshot.Ranges {
+		portRange, exists := cfg.PortRanges[rangeSnapshot.Name]
+		if !exists {
+			return fmt.Errorf("端口范围 %s 在当前配置中不存在", rangeSnapshot.Name)
+		}
+		for _, record := range rangeSnapshot.Ports {
+			if record.Port < portRange.Start || record.Port > portRange.End {
+				return fmt.Errorf("端口 %d 超出当前配置中端口范围 %s 的 [%d, %d]",
+					record.Port, rangeSnapshot.Name, portRange.Start, portRange.End)
+			}
+		}
+	}
+
+	for _, rangeSnapshot := range snapshot.Ranges {
+		portRange := cfg.PortRanges[rangeSnapshot.Name]
+		ports := rangeSnapshot.Ports
+		apply := func(state *portmanager.RangeState) (bool, error) {
+			changed := false
+			for _, record := range ports {
+				if state.BitSet.Test(record.Port) && (record.Owner == "" || state.Owner(record.Port) != "") {
+					continue
+				}
+				if err := state.Set(record.Port, record.Owner); err != nil {
+					return false, err
+				}
+				changed = true
+			}
+			return changed, nil
+		}
+
+		var err error
+		if mode == ImportModeReplace {
+			_, err = storage.ResetState(ctx, rangeSnapshot.Name, portRange.Start, portRange.End, apply)
+		} else {
+			_, err = storage.UpdateState(ctx, rangeSnapshot.Name, portRange.Start, portRange.End, apply)
+		}
+		if err != nil {
+			return fmt.Errorf("导入端口范围 %s 失败: %v", rangeSnapshot.Name, err)
+		}
+	}
+
+	return nil
+}
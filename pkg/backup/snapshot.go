@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// 快照格式版本
+const (
+	SnapshotAPIVersion = "nodeport-allocator/v1"
+	SnapshotKind       = "StateSnapshot"
+)
+
+// 快照文件格式
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Snapshot 端口分配状态快照
+type Snapshot struct {
+	APIVersion string          `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string          `json:"kind" yaml:"kind"`
+	CreatedAt  string          `json:"createdAt" yaml:"createdAt"`
+	Ranges     []RangeSnapshot `json:"ranges" yaml:"ranges"`
+}
+
+// RangeSnapshot 单个端口范围的快照
+type RangeSnapshot struct {
+	Name  string       `json:"name" yaml:"name"`
+	Start int32        `json:"start" yaml:"start"`
+	End   int32        `json:"end" yaml:"end"`
+	Ports []PortRecord `json:"ports" yaml:"ports"`
+}
+
+// PortRecord 已使用的端口及其所属 Service（namespace/name），所属未知时为空
+type PortRecord struct {
+	Port  int32  `json:"port" yaml:"port"`
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+}
+
+// FormatForPath 根据文件扩展名推断快照格式，默认 JSON
+func FormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return FormatJSON
+	}
+}
+
+// Write 以指定格式输出快照
+func (s *Snapshot) Write(w io.Writer, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case FormatJSON:
+		data, err = json.MarshalIndent(s, "", "  ")
+		data = append(data, '\n')
+	case FormatYAML:
+		data, err = yaml.Marshal(s)
+	default:
+		return fmt.Errorf("不支持的快照格式 %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("序列化快照失败: %v", err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadSnapshot 读取快照并校验版本
+func ReadSnapshot(r io.Reader, format string) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("读取快照失败: %v", err)
+	}
+
+	var snapshot Snapshot
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(data, &snapshot)
+	case FormatYAML:
+		err = yaml.Unmarshal(data, &snapshot)
+	default:
+		return nil, fmt.Errorf("不支持的快照格式 %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析快照失败: %v", err)
+	}
+
+	if snapshot.APIVersion != SnapshotAPIVersion || snapshot.Kind != SnapshotKind {
+		return nil, fmt.Errorf("不支持的快照版本 %s/%s，期望 %s/%s",
+			snapshot.APIVersion, snapshot.Kind, SnapshotAPIVersion, SnapshotKind)
+	}
+
+	for _, rangeSnapshot := range snapshot.Ranges {
+		for _, record := range rangeSnapshot.Ports {
+			if record.Port < rangeSnapshot.Start || record.Port > rangeSnapshot.End {
+				return nil, fmt.Errorf("快照中端口范围 %s 的端口 %d 超出 [%d, %d]",
+					rangeSnapshot.Name, record.Port, rangeSnapshot.Start, rangeSnapshot.End)
+			}
+		}
+	}
+
+	return &snapshot, nil
+}
+
+// Range 按名称查找范围快照
+func (s *Snapshot) Range(name string) *RangeSnapshot {
+	for i := range s.Ranges {
+		if s.Ranges[i].Name == name {
+			return &s.Ranges[i]
+		}
+	}
+	return nil
+}
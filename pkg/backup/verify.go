@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+// Finding 校验快照时发现的问题
+type Finding struct {
+	Range   string `json:"range,omitempty"`
+	Port    int32  `json:"port,omitempty"`
+	Message string `json:"message"`
+}
+
+// Verify 将快照与当前配置及集群中的 Services 对比，返回不一致之处
+func Verify(ctx context.Context, snapshot *Snapshot, reader client.Reader, cfg *config.Config) ([]Finding, error) {
+	owners, err := liveOwners(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	recorded := make(map[int32]bool)
+
+	for _, rangeSnapshot := range snapshot.Ranges {
+		portRange, exists := cfg.PortRanges[rangeSnapshot.Name]
+		if !exists {
+			findings = append(findings, Finding{Range: rangeSnapshot.Name, Message: "端口范围在当前配置中不存在"})
+		} else if portRange.Start != rangeSnapshot.Start || portRange.End != rangeSnapshot.End {
+			findings = append(findings, Finding{Range: rangeSnapshot.Name, Message: fmt.Sprintf(
+				"端口范围与当前配置不一致：快照 [%d, %d]，配置 [%d, %d]",
+				rangeSnapshot.Start, rangeSnapshot.End, portRange.Start, portRange.End)})
+		}
+
+		for _, record := range rangeSnapshot.Ports {
+			recorded[record.Port] = true
+			owner, used := owners[record.Port]
+			switch {
+			case !used:
+				findings = append(findings, Finding{Range: rangeSnapshot.Name, Port: record.Port,
+					Message: "快照中已使用，但集群中没有 Service 使用该端口"})
+			case record.Owner != "" && record.Owner != owner:
+				findings = append(findings, Finding{Range: rangeSnapshot.Name, Port: record.Port,
+					Message: fmt.Sprintf("快照记录的所属 Service 为 %s，集群中实际为 %s", record.Owner, owner)})
+			}
+		}
+	}
+
+	var missing []int32
+	for port := range owners {
+		if !recorded[port] {
+			missing = append(missing, port)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	for _, port := range missing {
+		findings = append(findings, Finding{Port: port,
+			Message: fmt.Sprintf("Service %s 使用该端口，但快照中未记录", owners[port])})
+	}
+
+	return findings, nil
+}
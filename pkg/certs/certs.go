@@ -0,0 +1,152 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// KeyPair PEM 编码的证书与私钥
+type KeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+// NewCA 生成自签名 CA
+func NewCA(commonName string, validity time.Duration, now time.Time) (*KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return newKeyPair(template, nil, nil)
+}
+
+// NewServingCert 使用 CA 签发服务证书，dnsNames 为 Webhook Service 的 DNS 名称
+func NewServingCert(ca *KeyPair, dnsNames []string, validity time.Duration, now time.Time) (*KeyPair, error) {
+	caCert, caKey, err := ca.parse()
+	if err != nil {
+		return nil, fmt.Errorf("解析CA失败: %v", err)
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return newKeyPair(template, caCert, caKey)
+}
+
+// newKeyPair 生成私钥并签发证书，parent 为 nil 时自签名
+func newKeyPair(template, parent *x509.Certificate, parentKey crypto.Signer) (*KeyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("生成私钥失败: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("生成证书序列号失败: %v", err)
+	}
+	template.SerialNumber = serial
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, fmt.Errorf("签发证书失败: %v", err)
+	}
+
+	return &KeyPair{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}, nil
+}
+
+// parse 解析证书与私钥，证书为多个时取第一个
+func (kp *KeyPair) parse() (*x509.Certificate, crypto.Signer, error) {
+	cert, err := parseCertificate(kp.Cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(kp.Key)
+	if block == nil {
+		return nil, nil, fmt.Errorf("私钥不是有效的PEM格式")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析私钥失败: %v", err)
+	}
+	return cert, key, nil
+}
+
+// parseCertificate 解析 PEM 中的第一个证书
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("证书不是有效的PEM格式")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// firstCertificate 返回 PEM 证书链中的第一个证书
+func firstCertificate(data []byte) []byte {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// needsRotation 判断证书是否需要重新签发：无法解析、将在 rotateBefore 内到期，
+// 或（ca 不为 nil 时）不是由 ca 签发、缺少 dnsNames 中的名称
+func needsRotation(kp *KeyPair, ca *x509.Certificate, dnsNames []string, rotateBefore time.Duration, now time.Time) bool {
+	cert, _, err := kp.parse()
+	if err != nil || now.Add(rotateBefore).After(cert.NotAfter) {
+		return true
+	}
+	if ca == nil {
+		return false
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return true
+	}
+	for _, name := range dnsNames {
+		if err := cert.VerifyHostname(name); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// appendValid 在 bundle 后追加 PEM 中仍未过期的证书，用于 CA 轮换期间同时信任新旧 CA
+func appendValid(bundle, certs []byte, now time.Time) []byte {
+	result := bytes.Clone(bundle)
+	for rest := certs; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return result
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || now.After(cert.NotAfter) || bytes.Contains(result, pem.EncodeToMemory(block)) {
+			continue
+		}
+		result = append(result, pem.EncodeToMemory(block)...)
+	}
+}
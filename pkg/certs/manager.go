@@ -0,0 +1,204 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/utils"
+)
+
+// Secret 中保存 CA 的键，服务证书使用 kubernetes.io/tls 的标准键
+const (
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+)
+
+// DefaultCheckInterval 检查证书是否需要轮换的默认间隔
+const DefaultCheckInterval = 10 * time.Minute
+
+// caValidityFactor CA 有效期相对服务证书的倍数，服务证书轮换时 caBundle 不需要变化
+const caValidityFactor = 10
+
+// Manager 管理 Webhook 的自签名证书：证书保存在 Secret 中供所有副本共享，
+// 写入证书目录后由 Webhook 服务器的证书监听自动加载，无需重启
+type Manager struct {
+	Client        client.Client
+	Reader        client.Reader
+	Namespace     string
+	SecretName    string
+	DNSNames      []string
+	CertDir       string
+	Validity      time.Duration
+	RotateBefore  time.Duration
+	CheckInterval time.Duration
+	// OnCABundle 每次检查后以当前的 caBundle 调用，用于创建/更新 Webhook 配置
+	OnCABundle func(ctx context.Context, caBundle []byte) error
+	Logger     logr.Logger
+}
+
+// DNSNames 返回 Webhook Service 的 DNS 名称
+func DNSNames(service, namespace string) []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, namespace),
+		fmt.Sprintf("%s.%s", service, namespace),
+		service,
+	}
+}
+
+// Start 实现 manager.Runnable，定期检查并轮换证书
+func (m *Manager) Start(ctx context.Context) error {
+	interval := m.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.Ensure(ctx); err != nil {
+				m.Logger.Error(err, "检查Webhook证书失败")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection 所有副本都需要加载证书
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
+
+// Ensure 确保 Secret 中有有效的证书（缺失或即将到期时重新签发），写入证书目录并更新 caBundle
+// 需在 Webhook 服务器启动前调用一次，保证证书文件存在
+func (m *Manager) Ensure(ctx context.Context) error {
+	var secret *corev1.Secret
+	err := utils.RetryOnConflict(ctx, 5, 200*time.Millisecond, func() error {
+		var err error
+		secret, err = m.ensureSecret(ctx, time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := m.writeFiles(secret); err != nil {
+		return err
+	}
+
+	if m.OnCABundle != nil {
+		return m.OnCABundle(ctx, secret.Data[caCertKey])
+	}
+	return nil
+}
+
+// ensureSecret 读取证书 Secret，需要时签发新证书并以 resourceVersion 写回；
+// 多个副本同时轮换时只有一个写入成功，其余副本冲突后重新读取
+func (m *Manager) ensureSecret(ctx context.Context, now time.Time) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := m.Reader.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.SecretName}, secret)
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("获取证书Secret失败: %v", err)
+	}
+	if !exists {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: m.Namespace, Name: m.SecretName},
+			Type:       corev1.SecretTypeTLS,
+		}
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	changed, err := m.rotate(secret.Data, now)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return secret, nil
+	}
+
+	if !exists {
+		if err := m.Client.Create(ctx, secret); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				// 其他副本已创建，按冲突处理以便重新读取
+				return nil, apierrors.NewConflict(corev1.Resource("secrets"), m.SecretName, err)
+			}
+			return nil, fmt.Errorf("创建证书Secret失败: %w", err)
+		}
+	} else if err := m.Client.Update(ctx, secret); err != nil {
+		return nil, fmt.Errorf("更新证书Secret失败: %w", err)
+	}
+
+	m.Logger.Info("Webhook证书已签发", "secret", client.ObjectKeyFromObject(secret))
+	return secret, nil
+}
+
+// rotate 按需签发 CA 与服务证书，返回是否有修改
+// CA 轮换后 caBundle 中保留仍未过期的旧 CA，使尚未加载新证书的副本在过渡期内仍被信任
+func (m *Manager) rotate(data map[string][]byte, now time.Time) (bool, error) {
+	changed := false
+
+	ca := &KeyPair{Cert: data[caCertKey], Key: data[caKeyKey]}
+	if needsRotation(ca, nil, nil, m.RotateBefore, now) {
+		newCA, err := NewCA("nodeport-allocator-webhook-ca", m.Validity*caValidityFactor, now)
+		if err != nil {
+			return false, err
+		}
+		newCA.Cert = appendValid(newCA.Cert, data[caCertKey], now)
+		ca = newCA
+		data[caCertKey], data[caKeyKey] = ca.Cert, ca.Key
+		changed = true
+	}
+
+	caCert, _, err := ca.parse()
+	if err != nil {
+		return false, fmt.Errorf("解析CA失败: %v", err)
+	}
+
+	serving := &KeyPair{Cert: data[corev1.TLSCertKey], Key: data[corev1.TLSPrivateKeyKey]}
+	if changed || needsRotation(serving, caCert, m.DNSNames, m.RotateBefore, now) {
+		serving, err = NewServingCert(&KeyPair{Cert: firstCertificate(ca.Cert), Key: ca.Key}, m.DNSNames, m.Validity, now)
+		if err != nil {
+			return false, err
+		}
+		data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey] = serving.Cert, serving.Key
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// writeFiles 将服务证书写入证书目录，内容未变化时不写入，避免触发不必要的重新加载
+// 先写私钥再写证书，证书监听在两者匹配后才会加载成功
+func (m *Manager) writeFiles(secret *corev1.Secret) error {
+	if err := os.MkdirAll(m.CertDir, 0o700); err != nil {
+		return fmt.Errorf("创建证书目录失败: %v", err)
+	}
+
+	for _, key := range []string{corev1.TLSPrivateKeyKey, corev1.TLSCertKey} {
+		path := filepath.Join(m.CertDir, key)
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, secret.Data[key]) {
+			continue
+		}
+		if err := os.WriteFile(path, secret.Data[key], 0o600); err != nil {
+			return fmt.Errorf("写入证书文件 %s 失败: %v", path, err)
+		}
+		m.Logger.Info("已更新证书文件", "path", path)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/util/validation"
+
+    "github.com/tiggoins/nodeport-allocator/pkg/i18n"
+)
+
+// Severity 配置检查结果的级别
+type Severity string
+
+const (
+    // SeverityError 配置无法加载
+    SeverityError Severity = "error"
+    // SeverityWarning 配置可以加载，但行为可能与预期不符
+    SeverityWarning Severity = "warning"
+)
+
+// Finding 配置检查发现的问题
+type Finding struct {
+    Severity Severity `json:"severity"`
+    Range    string   `json:"range,omitempty"`
+    Message  string   `json:"message"`
+}
+
+// NodePortRange 集群允许的 NodePort 范围，对应 kube-apiserver 的 --service-node-port-range
+type NodePortRange struct {
+    Start int32
+    End   int32
+}
+
+// DefaultNodePortRange kube-apiserver 默认的 NodePort 范围
+var DefaultNodePortRange = NodePortRange{Start: 30000, End: 32767}
+
+// ParseNodePortRange 解析 "30000-32767" 格式的端口范围，
+// 也支持 kube-apiserver --service-node-port-range 的 "<base>+<size>" 格式（如 "30000+2768"）
+func ParseNodePortRange(value string) (NodePortRange, error) {
+    separator, endName := "-", "结束端口"
+    if strings.Contains(value, "+") {
+        separator, endName = "+", "端口数量"
+    }
+    parts := strings.SplitN(strings.TrimSpace(value), separator, 2)
+    if len(parts) != 2 {
+        return NodePortRange{}, fmt.Errorf("NodePort 范围 %q 格式无效，应为 <start>-<end> 或 <base>+<size>", value)
+    }
+
+    start, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+    if err != nil {
+        return NodePortRange{}, fmt.Errorf("NodePort 范围 %q 的起始端口无效: %v", value, err)
+    }
+    end, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+    if err != nil {
+        return NodePortRange{}, fmt.Errorf("NodePort 范围 %q 的%s无效: %v", value, endName, err)
+    }
+    if separator == "+" {
+        // <base>+<size> 表示从 base 开始的 size 个端口
+        if end <= 0 {
+            return NodePortRange{}, fmt.Errorf("NodePort 范围 %q 无效，端口数量必须为正数", value)
+        }
+        end = start + end - 1
+    }
+    if start <= 0 || end > 65535 || start > end {
+        return NodePortRange{}, fmt.Errorf("NodePort 范围 %q 无效", value)
+    }
+
+    return NodePortRange{Start: int32(start), End: int32(end)}, nil
+}
+
+// String 以 "<start>-<end>" 格式输出
+func (r NodePortRange) String() string {
+    return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Contains 判断端口是否在范围内
+func (r NodePortRange) Contains(port int32) bool {
+    return port >= r.Start && port <= r.End
+}
+
+// Check 检查配置，返回所有问题；错误级别的问题（如范围重叠）会导致 LoadConfig 失败，
+// 警告级别的问题（如范围被遮蔽）只由 validate 命令报告
+func Check(config *Config, nodePortRange NodePortRange) []Finding {
+    var findings []Finding
+    addError := func(rangeName, format string, args ...interface{}) {
+        findings = append(findings, Finding{Severity: SeverityError, Range: rangeName, Message: fmt.Sprintf(format, args...)})
+    }
+
+    if len(config.PortRanges) == 0 {
+        addError("", "至少需要配置一个端口范围")
+    }
+
+    if config.DefaultRange == "" {
+        addError("", "必须指定默认端口范围")
+    } else if _, exists := config.PortRanges[config.DefaultRange]; !exists {
+        addError(config.DefaultRange, "默认端口范围 %s 不存在", config.DefaultRange)
+    }
+
+    names := config.sortedRangeNames()
+    for _, name := range names {
+        // 范围名称用作存储 ConfigMap 中的键，不能含 "."，以免与 <name>.owners、shard-index.json 等键冲突
+        if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+            addError(name, "端口范围名称 %s 无效，必须为 DNS 标签（小写字母、数字和 -，不含 .）: %s", name, strings.Join(errs, "; "))
+        }
+        portRange := config.PortRanges[name]
+        if portRange.Start <= 0 || portRange.End <= 0 {
+            addError(name, "端口范围 %s 的起始或结束端口无效", name)
+        } else if portRange.Start >= portRange.End {
+            addError(name, "端口范围 %s 的起始端口必须小于结束端口", name)
+        } else if !nodePortRange.Contains(portRange.Start) || !nodePortRange.Contains(portRange.End) {
+            addError(name, "端口范围 %s 超出 NodePort 允许范围 (%s)", name, nodePortRange)
+        }
+    }
+
+    switch config.RehomePolicy {
+    case RehomePolicyKeep, RehomePolicyReallocate, RehomePolicyDeny:
+    default:
+        addError("", "不支持的 rehomePolicy %s，可选值: %s, %s, %s",
+            config.RehomePolicy, RehomePolicyKeep, RehomePolicyReallocate, RehomePolicyDeny)
+    }
+
+    if _, ok := i18n.Parse(config.Language); !ok {
+        addError("", "不支持的语言 %s，可选值: %s, %s", config.Language, i18n.Chinese, i18n.English)
+    }
+
+    if err := validateStorage(config); err != nil {
+        addError("", "%v", err)
+    }
+    if err := validateFinalizer(&config.Finalizer); err != nil {
+        addError("", "%v", err)
+    }
+    if err := validateRelease(&config.Release); err != nil {
+        addError("", "%v", err)
+    }
+    if err := validateWebhook(&config.Webhook); err != nil {
+        addError("", "%v", err)
+    }
+    if err := validateHighAvailability(&config.HighAvailability); err != nil {
+        addError("", "%v", err)
+    }
+
+    // 范围重叠：每个范围有独立的位图，重叠部分会被两个范围重复分配，因此不允许
+    for i, name := range names {
+        for _, other := range names[i+1:] {
+            a, b := config.PortRanges[name], config.PortRanges[other]
+            if a.Start <= b.End && b.Start <= a.End {
+                addError(name, "端口范围 %s [%d, %d] 与 %s [%d, %d] 重叠，各范围的端口不能交叉",
+                    name, a.Start, a.End, other, b.Start, b.End)
+            }
+        }
+    }
+
+    findings = append(findings, checkReachability(config, names)...)
+    return findings
+}
+
+// checkReachability 检查无法被任何 Service 命中或存在歧义的范围
+// 匹配优先级与 MatchPortRangeForService 一致：标签 > 命名空间精确匹配 > 通配符，同级按名称排序
+func checkReachability(config *Config, names []string) []Finding {
+    var findings []Finding
+    addWarning := func(rangeName, format string, args ...interface{}) {
+        findings = append(findings, Finding{Severity: SeverityWarning, Range: rangeName, Message: fmt.Sprintf(format, args...)})
+    }
+
+    namespaceOwner := make(map[string]string)
+    wildcardRange := ""
+    for _, name := range names {
+        portRange := config.PortRanges[name]
+        for _, ns := range portRange.Namespaces {
+            if ns == "*" {
+                if wildcardRange != "" {
+                    addWarning(name, "端口范围 %s 与 %s 都配置了通配符 *，只有 %s 会被命中", wildcardRange, name, wildcardRange)
+                } else {
+                    wildcardRange = name
+                }
+                continue
+            }
+            if owner, exists := namespaceOwner[ns]; exists {
+                addWarning(name, "命名空间 %s 同时出现在端口范围 %s 和 %s 中，只有 %s 会被命中", ns, owner, name, owner)
+            } else {
+                namespaceOwner[ns] = name
+            }
+        }
+
+        if len(portRange.Namespaces) == 0 && len(portRange.Labels) == 0 && name != config.DefaultRange {
+            addWarning(name, "端口范围 %s 未配置 namespaces 或 labels，无法被任何 Service 命中", name)
+        }
+    }
+
+    for i, name := range names {
+        selector := config.PortRanges[name].Labels
+        if len(selector) == 0 {
+            continue
+        }
+        for _, other := range names[i+1:] {
+            if reflect.DeepEqual(selector, config.PortRanges[other].Labels) {
+                addWarning(other, "端口范围 %s 与 %s 的标签选择器相同，只有 %s 会被命中", name, other, name)
+            }
+        }
+    }
+
+    // 非默认范围配置了通配符时，不会再回退到默认范围
+    if defaultRange, exists := config.PortRanges[config.DefaultRange]; exists && wildcardRange != "" && wildcardRange != config.DefaultRange {
+        ownNamespaces := 0
+        for _, ns := range defaultRange.Namespaces {
+            if ns != "*" {
+                ownNamespaces++
+            }
+        }
+        if ownNamespaces == 0 && len(defaultRange.Labels) == 0 {
+            addWarning(config.DefaultRange, "默认端口范围 %s 被通配范围 %s 遮蔽，无法被任何 Service 命中", config.DefaultRange, wildcardRange)
+        }
+    }
+
+    return findings
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckRangeNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  []string
+		wantErr bool
+	}{
+		{name: "dns labels", ranges: []string{"production", "team-a"}},
+		{name: "collides with owners key", ranges: []string{"x", "x.owners"}, wantErr: true},
+		{name: "collides with shard index", ranges: []string{"x", "shard-index.json"}, wantErr: true},
+		{name: "uppercase", ranges: []string{"x", "Team"}, wantErr: true},
+		{name: "underscore", ranges: []string{"x", "team_a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				PortRanges:   make(map[string]PortRange),
+				DefaultRange: tt.ranges[0],
+				RehomePolicy: RehomePolicyKeep,
+				Language:     "zh",
+				StorageConfig: StorageConfig{
+					ConfigMapName:    "state",
+					RetryAttempts:    3,
+					RetryDelay:       "1s",
+					CorruptionPolicy: CorruptionPolicyFail,
+					FailurePolicy:    FailurePolicyFail,
+				},
+			}
+			for i, name := range tt.ranges {
+				start := int32(30000 + 10*i)
+				config.PortRanges[name] = PortRange{Start: start, End: start + 9, Namespaces: []string{name}}
+			}
+
+			var nameErrors []string
+			for _, finding := range Check(config, DefaultNodePortRange) {
+				if finding.Severity == SeverityError && strings.Contains(finding.Message, "名称") {
+					nameErrors = append(nameErrors, finding.Message)
+				}
+			}
+			if (len(nameErrors) > 0) != tt.wantErr {
+				t.Errorf("name errors = %v, wantErr %v", nameErrors, tt.wantErr)
+			}
+		})
+	}
+}
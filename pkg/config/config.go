@@ -1,15 +1,36 @@
 package config
 
 import (
+    "errors"
     "fmt"
     "os"
+    "sort"
+    "strings"
     "time"
 
     "gopkg.in/yaml.v2"
+    "k8s.io/apimachinery/pkg/util/validation"
+
+    "github.com/tiggoins/nodeport-allocator/pkg/i18n"
 )
 
-// LoadConfig 从文件加载配置
+// LoadConfig 从文件加载并验证配置
 func LoadConfig(configFile string) (*Config, error) {
+    config, err := ReadConfig(configFile)
+    if err != nil {
+        return nil, err
+    }
+
+    // 验证配置
+    if err := validateConfig(config); err != nil {
+        return nil, fmt.Errorf("配置验证失败: %v", err)
+    }
+
+    return config, nil
+}
+
+// ReadConfig 从文件读取配置并设置默认值，不做验证
+func ReadConfig(configFile string) (*Config, error) {
     data, err := os.ReadFile(configFile)
     if err != nil {
         return nil, fmt.Errorf("读取配置文件失败: %v", err)
@@ -21,6 +42,15 @@ func LoadConfig(configFile string) (*Config, error) {
     }
 
     // 设置默认值
+    if config.NodePortRange == "" {
+        config.NodePortRange = DefaultNodePortRange.String()
+    }
+    if config.ClusterNodePortRange, err = ParseNodePortRange(config.NodePortRange); err != nil {
+        return nil, fmt.Errorf("解析配置文件失败: %v", err)
+    }
+    if config.RehomePolicy == "" {
+        config.RehomePolicy = RehomePolicyKeep
+    }
     if config.StorageConfig.ConfigMapName == "" {
         config.StorageConfig.ConfigMapName = "nodeport-allocator-state"
     }
@@ -33,42 +63,116 @@ func LoadConfig(configFile string) (*Config, error) {
     if config.StorageConfig.RetryDelay == "" {
         config.StorageConfig.RetryDelay = "1s"
     }
+    if config.StorageConfig.CorruptionPolicy == "" {
+        config.StorageConfig.CorruptionPolicy = CorruptionPolicyFail
+    }
+    if config.StorageConfig.FailurePolicy == "" {
+        config.StorageConfig.FailurePolicy = FailurePolicyFail
+    }
+    if config.Finalizer.Domain == "" {
+        config.Finalizer.Domain = DefaultFinalizerDomain
+    }
+    if config.Finalizer.Timeout == "" {
+        config.Finalizer.Timeout = "10m"
+    }
+    if config.Release.Mode == "" {
+        config.Release.Mode = ReleaseModeFinalizer
+    }
+    if config.Release.SweepInterval == "" {
+        config.Release.SweepInterval = "5m"
+    }
+    webhook := &config.Webhook
+    if webhook.ServiceName == "" {
+        webhook.ServiceName = "nodeport-allocator"
+    }
+    if webhook.ServiceNamespace == "" {
+        webhook.ServiceNamespace = "kube-system"
+    }
+    if webhook.ServicePort == 0 {
+        webhook.ServicePort = 443
+    }
+    if webhook.SecretName == "" {
+        webhook.SecretName = "nodeport-allocator-webhook-cert"
+    }
+    if webhook.ConfigurationName == "" {
+        webhook.ConfigurationName = "nodeport-allocator"
+    }
+    if webhook.FailurePolicy == "" {
+        webhook.FailurePolicy = FailurePolicyFail
+    }
+    if webhook.CertValidity == "" {
+        webhook.CertValidity = "8760h"
+    }
+    if webhook.RotateBefore == "" {
+        webhook.RotateBefore = "720h"
+    }
     if config.LogLevel == "" {
         config.LogLevel = "info"
     }
-
-    // 验证配置
-    if err := validateConfig(&config); err != nil {
-        return nil, fmt.Errorf("配置验证失败: %v", err)
+    if config.Language == "" {
+        config.Language = string(i18n.Chinese)
+    }
+    if config.HighAvailability.Mode == "" {
+        config.HighAvailability.Mode = HAModeCAS
+    }
+    forward := &config.HighAvailability.LeaderForward
+    if forward.LeaseName == "" {
+        forward.LeaseName = "nodeport-allocator-allocation"
+    }
+    if forward.Port == 0 {
+        forward.Port = 9444
+    }
+    if forward.Timeout == "" {
+        forward.Timeout = "5s"
+    }
+    if forward.FailurePolicy == "" {
+        forward.FailurePolicy = FailurePolicyFail
     }
 
     return &config, nil
 }
 
-// validateConfig 验证配置的合法性
+// ApplyNodePortRange 使用自动发现的集群 NodePort 范围替换配置值，并重新验证配置
+// 验证失败时配置保持不变
+func (c *Config) ApplyNodePortRange(nodePortRange NodePortRange) error {
+    updated := *c
+    updated.NodePortRange = nodePortRange.String()
+    updated.ClusterNodePortRange = nodePortRange
+    if err := validateConfig(&updated); err != nil {
+        return fmt.Errorf("配置与集群 NodePort 范围 %s 不兼容: %v", nodePortRange, err)
+    }
+
+    c.NodePortRange = updated.NodePortRange
+    c.ClusterNodePortRange = nodePortRange
+    return nil
+}
+
+// validateConfig 验证配置的合法性，返回第一个错误级别的问题
 func validateConfig(config *Config) error {
-    if len(config.PortRanges) == 0 {
-        return fmt.Errorf("至少需要配置一个端口范围")
+    for _, finding := range Check(config, config.ClusterNodePortRange) {
+        if finding.Severity == SeverityError {
+            return errors.New(finding.Message)
+        }
     }
+    return nil
+}
 
-    if config.DefaultRange == "" {
-        return fmt.Errorf("必须指定默认端口范围")
+// validateStorage 验证存储配置
+func validateStorage(config *Config) error {
+    if config.StorageConfig.RangesPerShard < 0 {
+        return fmt.Errorf("rangesPerShard 不能为负数")
     }
 
-    if _, exists := config.PortRanges[config.DefaultRange]; !exists {
-        return fmt.Errorf("默认端口范围 %s 不存在", config.DefaultRange)
+    switch config.StorageConfig.CorruptionPolicy {
+    case CorruptionPolicyFail, CorruptionPolicyRebuild:
+    default:
+        return fmt.Errorf("不支持的 corruptionPolicy %s，可选值: %s, %s",
+            config.StorageConfig.CorruptionPolicy, CorruptionPolicyFail, CorruptionPolicyRebuild)
     }
 
-    for name, portRange := range config.PortRanges {
-        if portRange.Start <= 0 || portRange.End <= 0 {
-            return fmt.Errorf("端口范围 %s 的起始或结束端口无效", name)
-        }
-        if portRange.Start >= portRange.End {
-            return fmt.Errorf("端口范围 %s 的起始端口必须小于结束端口", name)
-        }
-        if portRange.Start < 30000 || portRange.End > 32767 {
-            return fmt.Errorf("端口范围 %s 超出 NodePort 允许范围 (30000-32767)", name)
-        }
+    if config.StorageConfig.FailurePolicy != FailurePolicyFail && config.StorageConfig.FailurePolicy != FailurePolicyIgnore {
+        return fmt.Errorf("不支持的存储 failurePolicy %s，可选值: %s, %s",
+            config.StorageConfig.FailurePolicy, FailurePolicyFail, FailurePolicyIgnore)
     }
 
     // 验证重试延迟格式
@@ -79,17 +183,146 @@ func validateConfig(config *Config) error {
     return nil
 }
 
+// validateHighAvailability 验证多副本模式配置
+func validateHighAvailability(ha *HighAvailability) error {
+    switch ha.Mode {
+    case HAModeCAS:
+        return nil
+    case HAModeLeader:
+    default:
+        return fmt.Errorf("不支持的多副本模式 %s，可选值: %s, %s", ha.Mode, HAModeCAS, HAModeLeader)
+    }
+
+    forward := ha.LeaderForward
+    if forward.TokenFile == "" {
+        return fmt.Errorf("Leader 转发模式必须配置 tokenFile")
+    }
+    if forward.Port <= 0 || forward.Port > 65535 {
+        return fmt.Errorf("Leader 转发端口 %d 无效", forward.Port)
+    }
+    if _, err := time.ParseDuration(forward.Timeout); err != nil {
+        return fmt.Errorf("Leader 转发超时格式无效: %v", err)
+    }
+    if forward.FailurePolicy != FailurePolicyFail && forward.FailurePolicy != FailurePolicyIgnore {
+        return fmt.Errorf("不支持的 failurePolicy %s，可选值: %s, %s", forward.FailurePolicy, FailurePolicyFail, FailurePolicyIgnore)
+    }
+
+    return nil
+}
+
+// validateFinalizer 验证 Finalizer 配置
+func validateFinalizer(finalizer *FinalizerConfig) error {
+    if errs := validation.IsQualifiedName(FinalizerName(finalizer.Domain)); len(errs) > 0 {
+        return fmt.Errorf("无效的 Finalizer 域名 %s: %s", finalizer.Domain, strings.Join(errs, "; "))
+    }
+
+    timeout, err := time.ParseDuration(finalizer.Timeout)
+    if err != nil {
+        return fmt.Errorf("解析 Finalizer 超时失败: %v", err)
+    }
+    if timeout <= 0 {
+        return fmt.Errorf("Finalizer 超时必须大于0")
+    }
+    return nil
+}
+
+// validateRelease 验证端口回收配置
+func validateRelease(release *ReleaseConfig) error {
+    if release.Mode != ReleaseModeFinalizer && release.Mode != ReleaseModeWatch {
+        return fmt.Errorf("不支持的端口回收方式 %s，可选值: %s, %s", release.Mode, ReleaseModeFinalizer, ReleaseModeWatch)
+    }
+
+    interval, err := time.ParseDuration(release.SweepInterval)
+    if err != nil {
+        return fmt.Errorf("解析端口清理间隔失败: %v", err)
+    }
+    if interval <= 0 {
+        return fmt.Errorf("端口清理间隔必须大于0")
+    }
+    return nil
+}
+
+// validateWebhook 验证 Webhook 自注册配置，未启用时不检查
+func validateWebhook(webhook *WebhookConfig) error {
+    if !webhook.SelfManaged {
+        return nil
+    }
+
+    if webhook.ServicePort <= 0 || webhook.ServicePort > 65535 {
+        return fmt.Errorf("Webhook Service 端口 %d 无效", webhook.ServicePort)
+    }
+    if webhook.FailurePolicy != FailurePolicyFail && webhook.FailurePolicy != FailurePolicyIgnore {
+        return fmt.Errorf("不支持的 Webhook failurePolicy %s，可选值: %s, %s", webhook.FailurePolicy, FailurePolicyFail, FailurePolicyIgnore)
+    }
+
+    validity, err := time.ParseDuration(webhook.CertValidity)
+    if err != nil {
+        return fmt.Errorf("解析证书有效期失败: %v", err)
+    }
+    rotateBefore, err := time.ParseDuration(webhook.RotateBefore)
+    if err != nil {
+        return fmt.Errorf("解析证书轮换时间失败: %v", err)
+    }
+    if rotateBefore <= 0 || rotateBefore >= validity {
+        return fmt.Errorf("证书轮换时间 %s 必须大于0且小于证书有效期 %s", webhook.RotateBefore, webhook.CertValidity)
+    }
+    return nil
+}
+
 // GetPortRangeForNamespace 获取指定命名空间的端口范围
 func (c *Config) GetPortRangeForNamespace(namespace string) (string, PortRange, error) {
-    // 查找匹配的端口范围
-    for rangeName, portRange := range c.PortRanges {
-        for _, ns := range portRange.Namespaces {
-            if ns == namespace || ns == "*" {
-                return rangeName, portRange, nil
+    return c.GetPortRangeForService(namespace, nil)
+}
+
+// MatchPortRangeForService 返回 Service 通过标签或命名空间显式命中的端口范围，
+// 未命中任何范围（只能回退到默认范围）时返回 false
+// 匹配优先级：标签 > 命名空间精确匹配 > 通配符 "*"，同一优先级内按范围名称排序
+func (c *Config) MatchPortRangeForService(namespace string, labels map[string]string) (string, bool) {
+    names := c.sortedRangeNames()
+
+    for _, rangeName := range names {
+        portRange := c.PortRanges[rangeName]
+        if len(portRange.Labels) > 0 && labelsMatch(portRange.Labels, labels) {
+            return rangeName, true
+        }
+    }
+
+    for _, rangeName := range names {
+        for _, ns := range c.PortRanges[rangeName].Namespaces {
+            if ns == namespace {
+                return rangeName, true
             }
         }
     }
 
+    for _, rangeName := range names {
+        for _, ns := range c.PortRanges[rangeName].Namespaces {
+            if ns == "*" {
+                return rangeName, true
+            }
+        }
+    }
+
+    return "", false
+}
+
+// labelsMatch 判断 labels 是否包含 selector 中的全部键值
+func labelsMatch(selector, labels map[string]string) bool {
+    for key, value := range selector {
+        if serviceValue, exists := labels[key]; !exists || serviceValue != value {
+            return false
+        }
+    }
+    return true
+}
+
+// GetPortRangeForService 获取指定Service的端口范围
+// 优先基于标签匹配，其次基于命名空间匹配，都未命中时返回默认范围
+func (c *Config) GetPortRangeForService(namespace string, labels map[string]string) (string, PortRange, error) {
+    if rangeName, matched := c.MatchPortRangeForService(namespace, labels); matched {
+        return rangeName, c.PortRanges[rangeName], nil
+    }
+
     // 返回默认范围
     defaultRange, exists := c.PortRanges[c.DefaultRange]
     if !exists {
@@ -99,25 +332,12 @@ func (c *Config) GetPortRangeForNamespace(namespace string) (string, PortRange,
     return c.DefaultRange, defaultRange, nil
 }
 
-// GetPortRangeForService 获取指定Service的端口范围
-func (c *Config) GetPortRangeForService(namespace string, labels map[string]string) (string, PortRange, error) {
-    // 首先尝试基于标签匹配
-    for rangeName, portRange := range c.PortRanges {
-        // 检查标签匹配
-        if len(portRange.Labels) > 0 {
-            match := true
-            for key, value := range portRange.Labels {
-                if serviceValue, exists := labels[key]; !exists || serviceValue != value {
-                    match = false
-                    break
-                }
-            }
-            if match {
-                return rangeName, portRange, nil
-            }
-        }
+// sortedRangeNames 按名称排序的端口范围列表，保证匹配结果稳定
+func (c *Config) sortedRangeNames() []string {
+    names := make([]string, 0, len(c.PortRanges))
+    for name := range c.PortRanges {
+        names = append(names, name)
     }
-
-    // 如果没有标签匹配，回退到基于namespace的匹配
-    return c.GetPortRangeForNamespace(namespace)
+    sort.Strings(names)
+    return names
 }
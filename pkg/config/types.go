@@ -5,8 +5,23 @@ type Config struct {
     PortRanges              map[string]PortRange `yaml:"portRanges"`
     DefaultRange            string               `yaml:"defaultRange"`
     AllowOutsideRangePorts  bool                 `yaml:"allowOutsideRangePorts"`
+    // NodePortRange 集群允许的 NodePort 范围，需与 kube-apiserver 的 --service-node-port-range 一致
+    NodePortRange           string               `yaml:"nodePortRange"`
+    // DiscoverNodePortRange 启动时从 kube-apiserver Pod 的启动参数中自动发现 NodePortRange
+    DiscoverNodePortRange   bool                 `yaml:"discoverNodePortRange"`
+    // RehomePolicy Service 更新后应属的端口范围发生变化时的处理策略：keep、reallocate 或 deny
+    RehomePolicy            string               `yaml:"rehomePolicy"`
     StorageConfig           StorageConfig        `yaml:"storage"`
+    Finalizer               FinalizerConfig      `yaml:"finalizer"`
+    Release                 ReleaseConfig        `yaml:"release"`
+    Webhook                 WebhookConfig        `yaml:"webhook"`
+    HighAvailability        HighAvailability     `yaml:"highAvailability"`
     LogLevel                string               `yaml:"logLevel"`
+    // Language 警告与拒绝消息的默认语言：zh 或 en，命名空间可通过注解覆盖
+    Language                string               `yaml:"language"`
+
+    // ClusterNodePortRange 解析后的 NodePortRange
+    ClusterNodePortRange    NodePortRange        `yaml:"-"`
 }
 
 // PortRange 端口范围配置
@@ -24,4 +39,108 @@ type StorageConfig struct {
     ConfigMapNamespace string `yaml:"configMapNamespace"`
     RetryAttempts      int    `yaml:"retryAttempts"`
     RetryDelay         string `yaml:"retryDelay"`
+    // RangesPerShard 每个分片 ConfigMap 保存的端口范围数量，0 表示不分片（全部保存在 configMapName 中）
+    RangesPerShard     int    `yaml:"rangesPerShard"`
+    // CorruptionPolicy 启动时发现状态数据损坏的处理策略：fail 拒绝启动，rebuild 从集群中的 Services 重建
+    CorruptionPolicy   string `yaml:"corruptionPolicy"`
+    // FailurePolicy 存储不可用时的处理策略：Fail 返回可重试的拒绝；
+    // Ignore 放行请求由 apiserver 分配端口，待存储恢复后由控制器登记
+    FailurePolicy      string `yaml:"failurePolicy"`
+}
+
+// Service 应属的端口范围变化（如修改标签）时的处理策略
+const (
+    // RehomePolicyKeep 保留原有端口，Service 继续使用原范围
+    RehomePolicyKeep = "keep"
+    // RehomePolicyReallocate 在新范围中重新分配不属于新范围的端口
+    RehomePolicyReallocate = "reallocate"
+    // RehomePolicyDeny 拒绝导致端口范围变化的更新
+    RehomePolicyDeny = "deny"
+)
+
+// DefaultFinalizerDomain Finalizer 的默认域名
+const DefaultFinalizerDomain = "nodeport-allocator.example.com"
+
+// FinalizerConfig Service Finalizer 配置
+type FinalizerConfig struct {
+    // Domain Finalizer 的域名，Finalizer 名称为 <domain>/finalizer
+    Domain  string `yaml:"domain"`
+    // Timeout Service 删除后端口回收持续失败的最长等待时间，超时后移除 Finalizer 放行删除
+    Timeout string `yaml:"timeout"`
+}
+
+// FinalizerName 返回指定域名下的 Service Finalizer 名称
+func FinalizerName(domain string) string {
+    return domain + "/finalizer"
+}
+
+// 端口回收方式
+const (
+    // ReleaseModeFinalizer 在 NodePort Service 上添加 Finalizer，删除前回收端口
+    ReleaseModeFinalizer = "finalizer"
+    // ReleaseModeWatch 不添加 Finalizer，根据删除事件中最后一次观察到的对象回收端口
+    ReleaseModeWatch = "watch"
+)
+
+// ReleaseConfig 端口回收配置
+type ReleaseConfig struct {
+    // Mode 端口回收方式：finalizer 或 watch
+    Mode          string `yaml:"mode"`
+    // SweepInterval watch 模式下定期清理已删除 Service 遗留端口的间隔，兜底错过的删除事件
+    SweepInterval string `yaml:"sweepInterval"`
+}
+
+// WebhookConfig Webhook 自注册与证书管理配置
+type WebhookConfig struct {
+    // SelfManaged 启用后自动生成自签名证书并保存到 Secret，创建/更新 Webhook 配置，到期前自动轮换证书
+    SelfManaged       bool   `yaml:"selfManaged"`
+    // ServiceName、ServiceNamespace、ServicePort apiserver 访问 Webhook 使用的 Service
+    ServiceName       string `yaml:"serviceName"`
+    ServiceNamespace  string `yaml:"serviceNamespace"`
+    ServicePort       int32  `yaml:"servicePort"`
+    // SecretName 保存 CA 与服务证书的 Secret，位于 ServiceNamespace
+    SecretName        string `yaml:"secretName"`
+    // ConfigurationName MutatingWebhookConfiguration 与 ValidatingWebhookConfiguration 的名称
+    ConfigurationName string `yaml:"configurationName"`
+    // FailurePolicy Webhook 不可用时 apiserver 的处理策略：Fail 或 Ignore
+    FailurePolicy     string `yaml:"failurePolicy"`
+    // CertValidity 服务证书有效期，CA 的有效期为其 10 倍
+    CertValidity      string `yaml:"certValidity"`
+    // RotateBefore 证书到期前多久轮换
+    RotateBefore      string `yaml:"rotateBefore"`
+}
+
+// 状态数据损坏的处理策略
+const (
+    CorruptionPolicyFail    = "fail"
+    CorruptionPolicyRebuild = "rebuild"
+)
+
+// 多副本模式
+const (
+    // HAModeCAS 各副本直接读写存储，依赖 resourceVersion 乐观并发控制
+    HAModeCAS = "cas"
+    // HAModeLeader 仅 Leader 修改端口状态，Follower 将分配/释放请求转发给 Leader
+    HAModeLeader = "leader"
+)
+
+// Leader 或存储不可达时的处理策略，与 Webhook 的 failurePolicy 取值保持一致
+const (
+    FailurePolicyFail   = "Fail"
+    FailurePolicyIgnore = "Ignore"
+)
+
+// HighAvailability 多副本部署配置
+type HighAvailability struct {
+    Mode          string              `yaml:"mode"`
+    LeaderForward LeaderForwardConfig `yaml:"leaderForward"`
+}
+
+// LeaderForwardConfig Leader 转发模式配置
+type LeaderForwardConfig struct {
+    LeaseName     string `yaml:"leaseName"`
+    Port          int    `yaml:"port"`
+    TokenFile     string `yaml:"tokenFile"`
+    Timeout       string `yaml:"timeout"`
+    FailurePolicy string `yaml:"failurePolicy"`
 }
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// deletedServices 保存 watch 模式下删除事件中最后一次观察到的 Service，供协调时回收端口
+type deletedServices struct {
+	services map[types.NamespacedName]*corev1.Service
+	mutex    sync.Mutex
+}
+
+func newDeletedServices() *deletedServices {
+	return &deletedServices{services: make(map[types.NamespacedName]*corev1.Service)}
+}
+
+// store 记录已删除的 Service，同名对象再次删除时以最新的为准
+func (d *deletedServices) store(service *corev1.Service) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.services[types.NamespacedName{Namespace: service.Namespace, Name: service.Name}] = service
+}
+
+// take 取出并移除已删除的 Service，不存在时返回 nil
+func (d *deletedServices) take(key types.NamespacedName) *corev1.Service {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	service := d.services[key]
+	delete(d.services, key)
+	return service
+}
+
+// restore 回收失败时放回，等待重试；期间如已记录更新的删除对象则保留更新的
+func (d *deletedServices) restore(service *corev1.Service) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+	if _, exists := d.services[key]; !exists {
+		d.services[key] = service
+	}
+}
+
+// serviceEventHandler 在入队前记录删除事件中的 Service，保证协调时能取到最后一次观察到的对象
+type serviceEventHandler struct {
+	handler.EnqueueRequestForObject
+	deleted *deletedServices
+}
+
+// Delete 记录已删除的 Service 并入队
+func (h *serviceEventHandler) Delete(ctx context.Context, evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	if service, ok := evt.Object.(*corev1.Service); ok && h.deleted != nil {
+		h.deleted.store(service.DeepCopy())
+	}
+	h.EnqueueRequestForObject.Delete(ctx, evt, q)
+}
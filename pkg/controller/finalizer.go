@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+// AnnotationForceDelete Service 上设置为 "true" 时，删除时即使端口回收失败也立即移除 Finalizer
+const AnnotationForceDelete = "nodeport-allocator.example.com/force-delete"
+
+// ServiceFinalizer 返回当前配置使用的 Service Finalizer
+func ServiceFinalizer(cfg *config.Config) string {
+	return config.FinalizerName(cfg.Finalizer.Domain)
+}
+
+// ServiceFinalizers 返回本分配器管理的所有 Finalizer：当前配置的，以及默认域名下的（修改域名前添加的）
+func ServiceFinalizers(cfg *config.Config) []string {
+	finalizers := []string{ServiceFinalizer(cfg)}
+	if legacy := config.FinalizerName(config.DefaultFinalizerDomain); legacy != finalizers[0] {
+		finalizers = append(finalizers, legacy)
+	}
+	return finalizers
+}
+
+// HasServiceFinalizer 判断对象上是否有本分配器管理的 Finalizer
+func HasServiceFinalizer(obj client.Object, cfg *config.Config) bool {
+	for _, finalizer := range ServiceFinalizers(cfg) {
+		if controllerutil.ContainsFinalizer(obj, finalizer) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveServiceFinalizers 移除对象上本分配器管理的所有 Finalizer，返回是否有修改
+func RemoveServiceFinalizers(obj client.Object, cfg *config.Config) bool {
+	removed := false
+	for _, finalizer := range ServiceFinalizers(cfg) {
+		if controllerutil.ContainsFinalizer(obj, finalizer) {
+			controllerutil.RemoveFinalizer(obj, finalizer)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// deletionExpired 判断是否应放弃等待端口回收：设置了强制删除注解，或删除已超过 Finalizer 超时
+func deletionExpired(obj client.Object, cfg *config.Config) bool {
+	if obj.GetAnnotations()[AnnotationForceDelete] == "true" {
+		return true
+	}
+
+	deletion := obj.GetDeletionTimestamp()
+	if deletion == nil {
+		return false
+	}
+	timeout, err := time.ParseDuration(cfg.Finalizer.Timeout)
+	if err != nil {
+		return false
+	}
+	return time.Since(deletion.Time) > timeout
+}
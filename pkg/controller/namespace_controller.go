@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// NamespaceReconciler 监听命名空间删除，批量回收命名空间下所有 Service 的端口
+// 避免命名空间删除时每个 Service 分别走 Finalizer 流程、逐个写入存储
+type NamespaceReconciler struct {
+	client.Client
+	PortManager *portmanager.Manager
+	Recorder    record.EventRecorder
+	Logger      logr.Logger
+}
+
+// Reconcile 协调Namespace资源
+func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Logger.WithValues("namespace", req.Name)
+
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, req.NamespacedName, &namespace); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "获取Namespace失败")
+			return ctrl.Result{}, err
+		}
+
+		// 命名空间已删除：清理 Service 被强制删除（绕过 Finalizer）后遗留的所属记录
+		released, err := r.PortManager.GetAllocator().ReleaseNamespace(ctx, req.Name)
+		if err != nil {
+			logger.Error(err, "清理已删除命名空间的端口失败")
+			return ctrl.Result{}, err
+		}
+		if len(released) > 0 {
+			logger.Info("已清理已删除命名空间遗留的端口", "ports", formatPorts(released))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if namespace.DeletionTimestamp.IsZero() && namespace.Status.Phase != corev1.NamespaceTerminating {
+		return ctrl.Result{}, nil
+	}
+
+	logger.Info("命名空间正在删除，批量回收端口")
+
+	// 先在一次存储写入中回收所有端口，再移除 Finalizer，随后的 Service 删除无需再写入存储
+	released, err := r.PortManager.GetAllocator().ReleaseNamespace(ctx, namespace.Name)
+	if err != nil {
+		logger.Error(err, "批量回收端口失败")
+		return ctrl.Result{}, err
+	}
+	if len(released) > 0 {
+		r.recordEvent(&namespace, corev1.EventTypeNormal, portmanager.EventReasonReleased,
+			i18n.EventNamespaceReleased, formatPorts(released))
+	}
+
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services, client.InNamespace(namespace.Name)); err != nil {
+		logger.Error(err, "列出Services失败")
+		return ctrl.Result{}, err
+	}
+
+	removed := 0
+	for i := range services.Items {
+		service := &services.Items[i]
+		patch := client.MergeFrom(service.DeepCopy())
+		if !RemoveServiceFinalizers(service, r.PortManager.GetConfig()) {
+			continue
+		}
+		if err := r.Patch(ctx, service, patch); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				continue
+			}
+			logger.Error(err, "移除Finalizer失败", "service", service.Name)
+			return ctrl.Result{}, err
+		}
+		removed++
+	}
+
+	logger.Info("命名空间端口回收完成", "released", len(released), "finalizersRemoved", removed)
+	return ctrl.Result{}, nil
+}
+
+// recordEvent 在 Namespace 上记录事件，未配置 Recorder 时忽略
+// 事件在请求之外产生，使用配置的默认语言
+func (r *NamespaceReconciler) recordEvent(namespace *corev1.Namespace, eventType, reason string, id i18n.MessageID, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(namespace, eventType, reason, i18n.Sprintf(i18n.Default(), id, args...))
+}
+
+// SetupWithManager 设置控制器，只关注正在删除和已删除的命名空间
+func (r *NamespaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	deleting := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Object.GetDeletionTimestamp() != nil
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectNew.GetDeletionTimestamp() != nil
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("namespace").
+		For(&corev1.Namespace{}, builder.WithPredicates(deleting)).
+		Complete(r)
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// ReleaseSweeper watch 模式下定期回收已删除 Service 遗留的端口，兜底错过的删除事件
+// （如控制器重启期间删除的 Service）
+// owner 需在连续两轮清理中都找不到对应的 Service 才会回收，避免误回收准入阶段刚分配、尚未持久化的 Service 的端口
+type ReleaseSweeper struct {
+	Client      client.Reader
+	PortManager *portmanager.Manager
+	Interval    time.Duration
+	Logger      logr.Logger
+
+	candidates map[string]bool
+}
+
+// Start 实现 manager.Runnable，随 Service 控制器一起只在 Leader 上运行
+func (s *ReleaseSweeper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.sweep(ctx); err != nil {
+				s.Logger.Error(err, "清理遗留端口失败")
+			}
+		}
+	}
+}
+
+// sweep 执行一轮清理
+func (s *ReleaseSweeper) sweep(ctx context.Context) error {
+	var services corev1.ServiceList
+	if err := s.Client.List(ctx, &services); err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(services.Items))
+	for i := range services.Items {
+		existing[portmanager.OwnerKey(&services.Items[i])] = true
+	}
+
+	orphans := make(map[string]bool)
+	for _, owner := range s.PortManager.Owners() {
+		if existing[owner] {
+			continue
+		}
+		if !s.candidates[owner] {
+			orphans[owner] = true
+			continue
+		}
+
+		namespace, name, err := cache.SplitMetaNamespaceKey(owner)
+		if err != nil {
+			s.Logger.Error(err, "无效的端口所属记录", "owner", owner)
+			continue
+		}
+
+		// ReleaseForService 会以 apiserver 中的最新对象为准，保留同名新 Service 使用的端口
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		if err := s.PortManager.GetAllocator().ReleaseForService(ctx, service); err != nil {
+			s.Logger.Error(err, "回收已删除Service的端口失败", "service", owner)
+			orphans[owner] = true
+			continue
+		}
+		s.Logger.Info("已回收已删除Service遗留的端口", "service", owner)
+	}
+
+	s.candidates = orphans
+	return nil
+}
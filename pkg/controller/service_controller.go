@@ -2,14 +2,21 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
 	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
 )
 
@@ -18,7 +25,11 @@ type ServiceReconciler struct {
 	client.Client
 	Scheme      *runtime.Scheme
 	PortManager *portmanager.Manager
+	Recorder    record.EventRecorder
 	Logger      logr.Logger
+
+	// deleted watch 模式下删除事件中最后一次观察到的 Service
+	deleted *deletedServices
 }
 
 // Reconcile 协调Service资源
@@ -31,6 +42,10 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	if err := r.Get(ctx, req.NamespacedName, &service); err != nil {
 		if client.IgnoreNotFound(err) == nil {
 			// Service已被删除，执行清理
+			if last := r.takeDeleted(req.NamespacedName); last != nil {
+				logger.Info("Service已删除，按最后观察到的对象回收端口")
+				return r.handleDeletedService(ctx, last)
+			}
 			logger.Info("Service已删除，执行端口回收")
 			return r.handleServiceDeletion(ctx, req.NamespacedName)
 		}
@@ -44,15 +59,37 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return r.handleServiceDeletion(ctx, req.NamespacedName)
 	}
 
-	// 只处理NodePort类型的Service
+	// 存储不可用时由 apiserver 分配的端口，在存储恢复后登记
+	if service.Spec.Type == corev1.ServiceTypeNodePort && portmanager.PendingRegistration(&service) {
+		if err := r.registerPendingPorts(ctx, &service); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 准入阶段新分配的端口在 Service 持久化后记录分配事件
+	if err := r.recordAllocation(ctx, &service); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	cfg := r.PortManager.GetConfig()
+
+	// watch 模式不添加 Finalizer，由删除事件触发回收
+	if cfg.Release.Mode == config.ReleaseModeWatch {
+		return r.reconcileWithoutFinalizer(ctx, &service)
+	}
+
+	// 只处理NodePort类型的Service；曾由本分配器管理的Service（类型已变更）需要回收端口并移除Finalizer
 	if service.Spec.Type != corev1.ServiceTypeNodePort {
-		logger.Info("跳过非NodePort类型的Service", "type", service.Spec.Type)
-		return ctrl.Result{}, nil
+		if !HasServiceFinalizer(&service, cfg) {
+			logger.Info("跳过非NodePort类型的Service", "type", service.Spec.Type)
+			return ctrl.Result{}, nil
+		}
+		return r.handleTypeChange(ctx, &service)
 	}
 
 	// 添加Finalizer以确保端口回收
-	if !controllerutil.ContainsFinalizer(&service, "nodeport-allocator.example.com/finalizer") {
-		controllerutil.AddFinalizer(&service, "nodeport-allocator.example.com/finalizer")
+	if !HasServiceFinalizer(&service, cfg) {
+		controllerutil.AddFinalizer(&service, ServiceFinalizer(cfg))
 		if err := r.Update(ctx, &service); err != nil {
 			logger.Error(err, "添加Finalizer失败")
 			return ctrl.Result{}, err
@@ -60,10 +97,148 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		logger.Info("已添加Finalizer")
 	}
 
+	// 回收更新后不再使用的端口（按所属记录查找）
+	if err := r.releaseUnusedPorts(ctx, &service); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	logger.Info("Service协调完成")
 	return ctrl.Result{}, nil
 }
 
+// registerPendingPorts 登记存储不可用时由 apiserver 分配的端口并移除待登记注解，存储仍不可用时返回错误以便重试
+// 端口已登记为其他 Service 所有时不覆盖所属记录，记录警告事件并保留注解，使该 Service 的更新不被拒绝，
+// 待冲突的所属记录被清理后在后续协调中重新登记
+func (r *ServiceReconciler) registerPendingPorts(ctx context.Context, service *corev1.Service) error {
+	registered, conflicts, err := r.PortManager.RegisterServicePorts(ctx, service)
+	if err != nil {
+		r.Logger.Error(err, "登记apiserver分配的端口失败", "service", client.ObjectKeyFromObject(service))
+		return err
+	}
+
+	if len(registered) > 0 {
+		r.recordEvent(service, corev1.EventTypeNormal, portmanager.EventReasonRegistered,
+			i18n.EventRegistered, formatPorts(registered))
+	}
+
+	if len(conflicts) > 0 {
+		for port, owner := range conflicts {
+			r.Logger.Info("端口已登记为其他Service所有，未覆盖所属记录",
+				"service", client.ObjectKeyFromObject(service), "port", port, "owner", owner)
+			r.recordEvent(service, corev1.EventTypeWarning, portmanager.EventReasonRegisterConflict,
+				i18n.EventRegisterConflict, port, owner)
+		}
+		return nil
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	delete(service.Annotations, portmanager.AnnotationPendingRegistration)
+	if err := r.Patch(ctx, service, patch); err != nil {
+		r.Logger.Error(err, "移除待登记注解失败", "service", client.ObjectKeyFromObject(service))
+		return err
+	}
+	return nil
+}
+
+// recordAllocation 为准入时标记的新分配端口记录 NodePortAllocated 事件并移除标记
+// 先移除标记再记录事件，移除失败重试时不会重复记录
+func (r *ServiceReconciler) recordAllocation(ctx context.Context, service *corev1.Service) error {
+	allocated := service.Annotations[portmanager.AnnotationUnrecordedAllocation]
+	if allocated == "" {
+		return nil
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	delete(service.Annotations, portmanager.AnnotationUnrecordedAllocation)
+	if err := r.Patch(ctx, service, patch); err != nil {
+		r.Logger.Error(err, "移除待记录分配注解失败", "service", client.ObjectKeyFromObject(service))
+		return err
+	}
+
+	r.recordEvent(service, corev1.EventTypeNormal, portmanager.EventReasonAllocated,
+		i18n.EventAllocated, allocated)
+	return nil
+}
+
+// releaseUnusedPorts 回收Service拥有但已不再使用的端口
+func (r *ServiceReconciler) releaseUnusedPorts(ctx context.Context, service *corev1.Service) error {
+	released, err := r.PortManager.GetAllocator().ReleaseUnusedForService(ctx, service)
+	if err != nil {
+		r.Logger.Error(err, "回收不再使用的端口失败", "service", client.ObjectKeyFromObject(service))
+		return err
+	}
+	if len(released) > 0 {
+		r.recordEvent(service, corev1.EventTypeNormal, portmanager.EventReasonReleased,
+			i18n.EventReleasedUnused, formatPorts(released))
+	}
+	return nil
+}
+
+// handleTypeChange 处理不再是NodePort类型的Service：回收其拥有的端口后移除Finalizer
+func (r *ServiceReconciler) handleTypeChange(ctx context.Context, service *corev1.Service) (ctrl.Result, error) {
+	logger := r.Logger.WithValues("service", client.ObjectKeyFromObject(service), "type", service.Spec.Type)
+
+	if err := r.releaseUnusedPorts(ctx, service); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if RemoveServiceFinalizers(service, r.PortManager.GetConfig()) {
+		if err := r.Update(ctx, service); err != nil {
+			logger.Error(err, "移除Finalizer失败")
+			return ctrl.Result{}, err
+		}
+		logger.Info("Service已不是NodePort类型，已移除Finalizer")
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileWithoutFinalizer watch 模式下协调Service：回收不再使用的端口，
+// 并移除切换到 watch 模式前添加的 Finalizer
+func (r *ServiceReconciler) reconcileWithoutFinalizer(ctx context.Context, service *corev1.Service) (ctrl.Result, error) {
+	logger := r.Logger.WithValues("service", client.ObjectKeyFromObject(service))
+
+	if err := r.releaseUnusedPorts(ctx, service); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if RemoveServiceFinalizers(service, r.PortManager.GetConfig()) {
+		if err := r.Update(ctx, service); err != nil {
+			logger.Error(err, "移除Finalizer失败")
+			return ctrl.Result{}, err
+		}
+		logger.Info("watch模式下不使用Finalizer，已移除")
+	}
+	return ctrl.Result{}, nil
+}
+
+// takeDeleted 取出 watch 模式下记录的已删除 Service
+func (r *ServiceReconciler) takeDeleted(key client.ObjectKey) *corev1.Service {
+	if r.deleted == nil {
+		return nil
+	}
+	return r.deleted.take(key)
+}
+
+// handleDeletedService watch 模式下按删除事件中最后观察到的对象回收端口，
+// 同名 Service 已重建时保留新对象使用的端口
+func (r *ServiceReconciler) handleDeletedService(ctx context.Context, service *corev1.Service) (ctrl.Result, error) {
+	logger := r.Logger.WithValues("service", client.ObjectKeyFromObject(service))
+
+	if err := r.PortManager.GetAllocator().ReleaseForService(ctx, service); err != nil {
+		// 删除事件不会重放，放回后重试；控制器重启后由定期清理兜底
+		logger.Error(err, "端口回收失败，稍后重试")
+		r.deleted.restore(service)
+		return ctrl.Result{}, err
+	}
+
+	if service.Spec.Type == corev1.ServiceTypeNodePort {
+		r.recordEvent(service, corev1.EventTypeNormal, portmanager.EventReasonReleased,
+			i18n.EventReleased, nodePorts(service))
+	}
+	logger.Info("Service删除处理完成")
+	return ctrl.Result{}, nil
+}
+
 // handleServiceDeletion 处理Service删除
 func (r *ServiceReconciler) handleServiceDeletion(ctx context.Context, namespacedName client.ObjectKey) (ctrl.Result, error) {
 	logger := r.Logger.WithValues("service", namespacedName)
@@ -79,18 +254,31 @@ func (r *ServiceReconciler) handleServiceDeletion(ctx context.Context, namespace
 		return ctrl.Result{}, err
 	}
 
-	// 执行端口回收
-	if service.Spec.Type == corev1.ServiceTypeNodePort {
+	cfg := r.PortManager.GetConfig()
+
+	// 执行端口回收：类型已变更但仍带有 Finalizer 的 Service（如回收不再使用的端口失败）
+	// 可能仍拥有端口，同样按所属记录回收
+	if service.Spec.Type == corev1.ServiceTypeNodePort || HasServiceFinalizer(&service, cfg) {
 		allocator := r.PortManager.GetAllocator()
 		if err := allocator.ReleaseForService(ctx, &service); err != nil {
+			if retryableRelease(err, cfg) && !deletionExpired(&service, cfg) {
+				// Leader 或存储不可用时保留 Finalizer，等待重试以免端口泄漏；
+				// 超过 finalizer.timeout 或设置了强制删除注解后放行，避免删除永远卡住
+				logger.Error(err, "Leader或存储不可用，稍后重试端口回收")
+				return ctrl.Result{}, err
+			}
 			logger.Error(err, "端口回收失败")
+			r.recordEvent(&service, corev1.EventTypeWarning, portmanager.EventReasonReleaseFailed,
+				i18n.EventReleaseFailed, err)
 			// 不阻塞删除过程，只记录错误
+		} else if service.Spec.Type == corev1.ServiceTypeNodePort {
+			r.recordEvent(&service, corev1.EventTypeNormal, portmanager.EventReasonReleased,
+				i18n.EventReleased, nodePorts(&service))
 		}
 	}
 
 	// 移除Finalizer
-	if controllerutil.ContainsFinalizer(&service, "nodeport-allocator.example.com/finalizer") {
-		controllerutil.RemoveFinalizer(&service, "nodeport-allocator.example.com/finalizer")
+	if RemoveServiceFinalizers(&service, cfg) {
 		if err := r.Update(ctx, &service); err != nil {
 			logger.Error(err, "移除Finalizer失败")
 			return ctrl.Result{}, err
@@ -102,9 +290,72 @@ func (r *ServiceReconciler) handleServiceDeletion(ctx context.Context, namespace
 	return ctrl.Result{}, nil
 }
 
+// retryableRelease 判断端口回收失败后是否应保留 Finalizer 重试：存储不可用，
+// 或 Leader 不可达且 failurePolicy 为 Fail
+func retryableRelease(err error, cfg *config.Config) bool {
+	if errors.Is(err, portmanager.ErrStorageUnavailable) {
+		return true
+	}
+	return errors.Is(err, portmanager.ErrLeaderUnavailable) &&
+		cfg.HighAvailability.LeaderForward.FailurePolicy == config.FailurePolicyFail
+}
+
+// recordEvent 在 Service 上记录事件，未配置 Recorder 时忽略
+// 事件在请求之外产生，使用配置的默认语言
+func (r *ServiceReconciler) recordEvent(service *corev1.Service, eventType, reason string, id i18n.MessageID, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(service, eventType, reason, i18n.Sprintf(i18n.Default(), id, args...))
+}
+
+// nodePorts 返回 Service 中已设置的 NodePort 列表
+func nodePorts(service *corev1.Service) string {
+	var ports []int32
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			ports = append(ports, port.NodePort)
+		}
+	}
+	return formatPorts(ports)
+}
+
+// formatPorts 将端口列表格式化为以逗号分隔的字符串
+func formatPorts(ports []int32) string {
+	parts := make([]string, 0, len(ports))
+	for _, port := range ports {
+		parts = append(parts, fmt.Sprintf("%d", port))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // SetupWithManager 设置控制器
+// watch 模式下记录删除事件中的 Service，并启动定期清理兜底错过的删除事件
 func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		Complete(r)
+	cfg := r.PortManager.GetConfig()
+	if cfg.Release.Mode != config.ReleaseModeWatch {
+		return ctrl.NewControllerManagedBy(mgr).
+			For(&corev1.Service{}).
+			Complete(r)
+	}
+
+	interval, err := time.ParseDuration(cfg.Release.SweepInterval)
+	if err != nil {
+		return fmt.Errorf("解析端口清理间隔失败: %v", err)
+	}
+
+	r.deleted = newDeletedServices()
+	if err := ctrl.NewControllerManagedBy(mgr).
+		Named("service").
+		Watches(&corev1.Service{}, &serviceEventHandler{deleted: r.deleted}).
+		Complete(r); err != nil {
+		return err
+	}
+
+	return mgr.Add(&ReleaseSweeper{
+		Client:      mgr.GetClient(),
+		PortManager: r.PortManager,
+		Interval:    interval,
+		Logger:      r.Logger.WithName("sweeper"),
+	})
 }
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// StateReconciler 监听端口状态ConfigMap，在其他副本写入后刷新本副本的内存缓存
+type StateReconciler struct {
+	PortManager *portmanager.Manager
+	Logger      logr.Logger
+}
+
+// Reconcile 刷新端口状态缓存
+func (r *StateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if err := r.PortManager.Refresh(ctx); err != nil {
+		r.Logger.Error(err, "刷新端口状态失败", "configmap", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	r.Logger.V(1).Info("端口状态已刷新", "configmap", req.NamespacedName)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager 设置控制器，所有副本都需要运行
+func (r *StateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isStateConfigMap := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return r.PortManager.IsStateObject(obj.GetNamespace(), obj.GetName())
+	})
+
+	needLeaderElection := false
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("state").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(isStateConfigMap)).
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
+		Complete(r)
+}
@@ -0,0 +1,191 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// Client Follower 侧的转发客户端，实现portmanager.Forwarder接口
+type Client struct {
+	election   Election
+	reader     client.Reader
+	port       int
+	token      string
+	httpClient *http.Client
+	logger     logr.Logger
+
+	mutex          sync.Mutex
+	cachedIdentity string
+	cachedAddress  string
+}
+
+// NewClient 创建转发客户端，通过 Leader 的 Pod IP 访问其内部转发服务
+func NewClient(election Election, reader client.Reader, port int, token string, timeout time.Duration, logger logr.Logger) *Client {
+	return &Client{
+		election:   election,
+		reader:     reader,
+		port:       port,
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+		logger:     logger,
+	}
+}
+
+// ShouldForward 当前副本不是 Leader 时需要转发
+func (c *Client) ShouldForward() bool {
+	return !c.election.IsLeader()
+}
+
+// AllocateForService 将分配请求转发给 Leader
+func (c *Client) AllocateForService(ctx context.Context, service *corev1.Service, rangeName string) ([]portmanager.AllocationResult, error) {
+	resp, err := c.forward(ctx, AllocatePath, Request{Service: service, RangeName: rangeName})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// ReleaseForService 将释放请求转发给 Leader
+func (c *Client) ReleaseForService(ctx context.Context, service *corev1.Service) error {
+	_, err := c.forward(ctx, ReleasePath, Request{Service: service})
+	return err
+}
+
+// ReleaseUnusedForService 将释放不再使用端口的请求转发给 Leader
+func (c *Client) ReleaseUnusedForService(ctx context.Context, service *corev1.Service) ([]int32, error) {
+	resp, err := c.forward(ctx, ReleaseUnusedPath, Request{Service: service})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Released, nil
+}
+
+// ReleaseNamespace 将按命名空间释放的请求转发给 Leader
+func (c *Client) ReleaseNamespace(ctx context.Context, namespace string) ([]int32, error) {
+	resp, err := c.forward(ctx, ReleaseNamespacePath, Request{Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Released, nil
+}
+
+// RegisterServicePorts 将登记 apiserver 分配端口的请求转发给 Leader
+func (c *Client) RegisterServicePorts(ctx context.Context, service *corev1.Service) ([]int32, map[int32]string, error) {
+	resp, err := c.forward(ctx, RegisterPath, Request{Service: service})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Registered, resp.Conflicts, nil
+}
+
+// forward 向 Leader 发送请求，网络异常或对端已不是 Leader（503）时返回 ErrLeaderUnavailable，
+// 其他非 200 状态码返回普通错误
+func (c *Client) forward(ctx context.Context, path string, request Request) (*Response, error) {
+	address, err := c.leaderAddress(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", portmanager.ErrLeaderUnavailable, err)
+	}
+
+	if lang, ok := i18n.LanguageFromContext(ctx); ok {
+		request.Language = string(lang)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("序列化转发请求失败: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+address+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("构造转发请求失败: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+
+	httpResp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		// 缓存的地址可能已失效（如 Leader Pod 重建后 IP 变化），下次请求重新解析
+		c.invalidateAddress(address)
+		return nil, fmt.Errorf("%w: %v", portmanager.ErrLeaderUnavailable, err)
+	}
+	defer httpResp.Body.Close()
+
+	switch httpResp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		// 对端已不再是 Leader，选举结果尚未同步到本副本
+		return nil, fmt.Errorf("%w: Leader 返回状态码 %d", portmanager.ErrLeaderUnavailable, httpResp.StatusCode)
+	default:
+		// 认证失败、请求无效等不会因重试或 Leader 切换而恢复，不按 Leader 不可用处理
+		message, _ := io.ReadAll(io.LimitReader(httpResp.Body, 1024))
+		return nil, fmt.Errorf("Leader 拒绝转发请求，状态码 %d: %s", httpResp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var resp Response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("%w: 解析转发响应失败: %v", portmanager.ErrLeaderUnavailable, err)
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+
+	c.logger.Info("请求已由Leader处理", "path", path, "leader", address,
+		"target", request.target())
+	return &resp, nil
+}
+
+// leaderAddress 解析当前 Leader 的内部转发地址
+func (c *Client) leaderAddress(ctx context.Context) (string, error) {
+	identity := c.election.LeaderIdentity()
+	if identity == "" {
+		return "", fmt.Errorf("当前没有已知的 Leader")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if identity == c.cachedIdentity {
+		return c.cachedAddress, nil
+	}
+
+	var pod corev1.Pod
+	key := types.NamespacedName{Namespace: c.election.Namespace(), Name: identity}
+	if err := c.reader.Get(ctx, key, &pod); err != nil {
+		return "", fmt.Errorf("获取 Leader Pod %s 失败: %v", identity, err)
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("Leader Pod %s 尚未分配 IP", identity)
+	}
+
+	c.cachedIdentity = identity
+	c.cachedAddress = net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(c.port))
+	return c.cachedAddress, nil
+}
+
+// invalidateAddress 清除缓存的 Leader 地址，仅当缓存仍为 address 时清除
+func (c *Client) invalidateAddress(address string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cachedAddress == address {
+		c.cachedIdentity = ""
+		c.cachedAddress = ""
+	}
+}
@@ -0,0 +1,151 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// Server Leader 侧的内部转发服务，接收 Follower 转发的分配/释放请求
+type Server struct {
+	port        int
+	token       string
+	election    Election
+	portManager *portmanager.Manager
+	logger      logr.Logger
+}
+
+// NewServer 创建内部转发服务
+func NewServer(port int, token string, election Election, portManager *portmanager.Manager, logger logr.Logger) *Server {
+	return &Server{
+		port:        port,
+		token:       token,
+		election:    election,
+		portManager: portManager,
+		logger:      logger,
+	}
+}
+
+// Start 启动内部转发服务，实现manager.Runnable接口
+func (s *Server) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", s.port),
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error(err, "关闭内部转发服务失败")
+		}
+	}()
+
+	s.logger.Info("启动内部转发服务", "port", s.port)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("内部转发服务运行出错: %v", err)
+	}
+	return nil
+}
+
+// handler 返回内部转发服务的路由，每个转发接口路径都由 ServeHTTP 处理
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, path := range []string{AllocatePath, ReleasePath, ReleaseUnusedPath, ReleaseNamespacePath, RegisterPath} {
+		mux.Handle(path, s)
+	}
+	return mux
+}
+
+// NeedLeaderElection 所有副本都需要监听，实现manager.LeaderElectionRunnable接口
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP 实现http.Handler接口
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorized(r, s.token) {
+		s.logger.Info("拒绝未认证的转发请求", "remote", r.RemoteAddr)
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	if !s.election.IsLeader() {
+		http.Error(w, "当前副本不是 Leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("解析转发请求失败: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.valid(r.URL.Path) {
+		http.Error(w, "转发请求缺少必要字段", http.StatusBadRequest)
+		return
+	}
+
+	logger := s.logger.WithValues("path", r.URL.Path, "target", req.target())
+	logger.Info("处理转发请求")
+
+	// 按 Follower 收到的请求所用语言生成消息
+	ctx := r.Context()
+	if lang, ok := i18n.Parse(req.Language); ok {
+		ctx = i18n.WithLanguage(ctx, lang)
+	}
+
+	allocator := s.portManager.GetAllocator()
+	var resp Response
+	switch r.URL.Path {
+	case AllocatePath:
+		results, err := allocator.AllocateForService(ctx, req.Service, req.RangeName)
+		if err != nil {
+			resp.setError(err)
+		}
+		resp.Results = results
+	case ReleasePath:
+		if err := allocator.ReleaseForService(ctx, req.Service); err != nil {
+			resp.setError(err)
+		}
+	case ReleaseUnusedPath:
+		released, err := allocator.ReleaseUnusedForService(ctx, req.Service)
+		if err != nil {
+			resp.setError(err)
+		}
+		resp.Released = released
+	case ReleaseNamespacePath:
+		released, err := allocator.ReleaseNamespace(ctx, req.Namespace)
+		if err != nil {
+			resp.setError(err)
+		}
+		resp.Released = released
+	case RegisterPath:
+		registered, conflicts, err := s.portManager.RegisterServicePorts(ctx, req.Service)
+		if err != nil {
+			resp.setError(err)
+		}
+		resp.Registered, resp.Conflicts = registered, conflicts
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error(err, "编码转发响应失败")
+	}
+}
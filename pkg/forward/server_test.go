@@ -0,0 +1,255 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+const (
+	testToken     = "secret"
+	testNamespace = "kube-system"
+	testLeader    = "allocator-0"
+)
+
+// fakeElection 固定的选举状态
+type fakeElection struct {
+	leader   bool
+	identity string
+}
+
+func (e *fakeElection) IsLeader() bool         { return e.leader }
+func (e *fakeElection) LeaderIdentity() string { return e.identity }
+func (e *fakeElection) Namespace() string      { return testNamespace }
+
+// newTestManager 创建基于 fake client 的端口管理器，objs 为集群中已有的对象
+func newTestManager(t *testing.T, objs ...client.Object) (*portmanager.Manager, client.Client) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+
+	cfg := &config.Config{
+		PortRanges: map[string]config.PortRange{
+			"team":    {Start: 30000, End: 30009, Namespaces: []string{"team"}},
+			"default": {Start: 30010, End: 30019, Namespaces: []string{"*"}},
+		},
+		DefaultRange:         "default",
+		ClusterNodePortRange: config.DefaultNodePortRange,
+		RehomePolicy:         config.RehomePolicyKeep,
+		StorageConfig: config.StorageConfig{
+			ConfigMapName:      "state",
+			ConfigMapNamespace: testNamespace,
+			RetryAttempts:      3,
+			RetryDelay:         "1ms",
+			CorruptionPolicy:   config.CorruptionPolicyFail,
+			FailurePolicy:      config.FailurePolicyFail,
+		},
+	}
+	manager, err := portmanager.NewManager(context.Background(), c, c, cfg, nil, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return manager, c
+}
+
+// newTestClient 创建指向 server 的转发客户端，Leader Pod 的 IP 为 server 的监听地址
+func newTestClient(t *testing.T, server *httptest.Server, token string) *Client {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, portText, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: testLeader, Namespace: testNamespace},
+		Status:     corev1.PodStatus{PodIP: host},
+	}
+	reader := fake.NewClientBuilder().WithObjects(pod).Build()
+	return NewClient(&fakeElection{identity: testLeader}, reader, port, token, 5*time.Second, logr.Discard())
+}
+
+func nodePortService(name string, nodePorts ...int32) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team", UID: types.UID("uid-" + name)},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	}
+	for i, nodePort := range nodePorts {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:     "p" + strconv.Itoa(i),
+			Port:     int32(80 + i),
+			NodePort: nodePort,
+		})
+	}
+	return service
+}
+
+// TestClientAgainstServer 通过 Start 使用的路由驱动客户端的每个方法，子测试按顺序共享端口状态
+func TestClientAgainstServer(t *testing.T) {
+	ctx := context.Background()
+	registered := nodePortService("registered", 30015)
+	unused := nodePortService("unused", 30003)
+	manager, _ := newTestManager(t, unused)
+
+	server := NewServer(0, testToken, &fakeElection{leader: true, identity: testLeader}, manager, logr.Discard())
+	ts := httptest.NewServer(server.handler())
+	defer ts.Close()
+	forwarder := newTestClient(t, ts, testToken)
+
+	if !forwarder.ShouldForward() {
+		t.Fatal("ShouldForward() = false on a follower")
+	}
+
+	tests := []struct {
+		name string
+		call func() (interface{}, error)
+		want interface{}
+	}{
+		{
+			name: "allocate",
+			call: func() (interface{}, error) {
+				results, err := forwarder.AllocateForService(ctx, nodePortService("web", 0), "")
+				if err != nil {
+					return nil, err
+				}
+				return results[0].AllocatedPort, nil
+			},
+			want: int32(30000),
+		},
+		{
+			name: "register",
+			call: func() (interface{}, error) {
+				ports, conflicts, err := forwarder.RegisterServicePorts(ctx, registered)
+				return []interface{}{ports, len(conflicts)}, err
+			},
+			want: []interface{}{[]int32{30015}, 0},
+		},
+		{
+			name: "register conflict",
+			call: func() (interface{}, error) {
+				_, conflicts, err := forwarder.RegisterServicePorts(ctx, nodePortService("other", 30015))
+				return conflicts, err
+			},
+			want: map[int32]string{30015: "team/registered"},
+		},
+		{
+			name: "release unused",
+			call: func() (interface{}, error) {
+				// unused 在集群中只使用 30003，30004 不再使用
+				if _, err := forwarder.AllocateForService(ctx, nodePortService("unused", 30003, 30004), ""); err != nil {
+					return nil, err
+				}
+				return forwarder.ReleaseUnusedForService(ctx, unused)
+			},
+			want: []int32{30004},
+		},
+		{
+			name: "release",
+			call: func() (interface{}, error) {
+				err := forwarder.ReleaseForService(ctx, nodePortService("web", 30000))
+				return manager.GetPortRange("team").IsPortUsed(30000), err
+			},
+			want: false,
+		},
+		{
+			name: "release namespace",
+			call: func() (interface{}, error) {
+				return forwarder.ReleaseNamespace(ctx, "team")
+			},
+			want: []int32{30003, 30015},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.call()
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClientStatusMapping 只有 503 视为 Leader 不可用，其他状态码为普通错误
+func TestClientStatusMapping(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	tests := []struct {
+		name            string
+		leader          bool
+		token           string
+		wantUnavailable bool
+	}{
+		{name: "not leader", leader: false, token: testToken, wantUnavailable: true},
+		{name: "unauthorized", leader: true, token: "wrong", wantUnavailable: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(0, testToken, &fakeElection{leader: tt.leader, identity: testLeader}, manager, logr.Discard())
+			ts := httptest.NewServer(server.handler())
+			defer ts.Close()
+
+			_, err := newTestClient(t, ts, tt.token).ReleaseNamespace(context.Background(), "team")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := errors.Is(err, portmanager.ErrLeaderUnavailable); got != tt.wantUnavailable {
+				t.Errorf("errors.Is(err, ErrLeaderUnavailable) = %v, want %v (err: %v)", got, tt.wantUnavailable, err)
+			}
+		})
+	}
+}
+
+// TestClientDropsCachedAddressOnTransportError 连接失败后重新解析 Leader 地址
+func TestClientDropsCachedAddressOnTransportError(t *testing.T) {
+	manager, _ := newTestManager(t)
+	server := NewServer(0, testToken, &fakeElection{leader: true, identity: testLeader}, manager, logr.Discard())
+	ts := httptest.NewServer(server.handler())
+	forwarder := newTestClient(t, ts, testToken)
+
+	if _, err := forwarder.ReleaseNamespace(context.Background(), "team"); err != nil {
+		t.Fatalf("ReleaseNamespace: %v", err)
+	}
+	ts.Close()
+
+	_, err := forwarder.ReleaseNamespace(context.Background(), "team")
+	if !errors.Is(err, portmanager.ErrLeaderUnavailable) {
+		t.Fatalf("error = %v, want ErrLeaderUnavailable", err)
+	}
+	if forwarder.cachedAddress != "" || forwarder.cachedIdentity != "" {
+		t.Errorf("cached leader address %q was kept after a transport error", forwarder.cachedAddress)
+	}
+}
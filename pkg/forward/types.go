@@ -0,0 +1,140 @@
+package forward
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/portmanager"
+)
+
+// 内部转发接口路径
+const (
+	AllocatePath = "/internal/v1/allocate"
+	ReleasePath  = "/internal/v1/release"
+	// ReleaseUnusedPath 释放 Service 拥有但已不再使用的端口
+	ReleaseUnusedPath = "/internal/v1/release-unused"
+	// ReleaseNamespacePath 释放命名空间下所有 Service 拥有的端口
+	ReleaseNamespacePath = "/internal/v1/release-namespace"
+	// RegisterPath 登记由 apiserver 分配的端口
+	RegisterPath = "/internal/v1/register"
+)
+
+// Election 当前副本观察到的 Leader 选举状态，由 *leader.Election 实现
+type Election interface {
+	// IsLeader 当前副本是否为 Leader
+	IsLeader() bool
+	// LeaderIdentity 当前 Leader 的 Pod 名称，未知时返回空字符串
+	LeaderIdentity() string
+	// Namespace Leader Pod 所在的命名空间
+	Namespace() string
+}
+
+// Request 转发请求
+type Request struct {
+	Service *corev1.Service `json:"service"`
+	// RangeName 分配时使用的端口范围，为空时由 Leader 按当前规则匹配
+	RangeName string `json:"rangeName,omitempty"`
+	// Namespace 按命名空间释放时的命名空间
+	Namespace string `json:"namespace,omitempty"`
+	// Language 生成用户可见消息使用的语言，为空时使用 Leader 的默认语言
+	Language string `json:"language,omitempty"`
+}
+
+// valid 检查请求是否包含指定路径所需的字段
+func (r *Request) valid(path string) bool {
+	if path == ReleaseNamespacePath {
+		return r.Namespace != ""
+	}
+	return r.Service != nil
+}
+
+// target 请求针对的 Service 或命名空间，用于日志
+func (r *Request) target() string {
+	if r.Service != nil {
+		return fmt.Sprintf("%s/%s", r.Service.Namespace, r.Service.Name)
+	}
+	return r.Namespace
+}
+
+// Response 转发响应，Error 非空表示 Leader 拒绝了该请求（如端口范围已满）
+// Denial 为带拒绝原因的分配错误，使 Follower 返回与 Leader 相同的结构化拒绝信息
+type Response struct {
+	Results    []portmanager.AllocationResult `json:"results,omitempty"`
+	Released   []int32                        `json:"released,omitempty"`
+	Registered []int32                        `json:"registered,omitempty"`
+	// Conflicts 登记时已属于其他 Service 的端口及其所属 Service
+	Conflicts map[int32]string             `json:"conflicts,omitempty"`
+	Error     string                       `json:"error,omitempty"`
+	Denial    *portmanager.AllocationError `json:"denial,omitempty"`
+	// StorageUnavailable Leader 处理请求时存储不可用，使 Follower 同样按存储不可用处理
+	StorageUnavailable bool `json:"storageUnavailable,omitempty"`
+}
+
+// setError 记录 Leader 处理请求时的错误
+func (r *Response) setError(err error) {
+	r.Error = err.Error()
+	var denial *portmanager.AllocationError
+	if errors.As(err, &denial) {
+		r.Denial = denial
+	}
+	r.StorageUnavailable = errors.Is(err, portmanager.ErrStorageUnavailable)
+}
+
+// err 返回 Leader 处理请求时的错误
+func (r *Response) err() error {
+	if r.Denial != nil {
+		return r.Denial
+	}
+	if r.StorageUnavailable {
+		return &remoteError{message: r.Error, cause: portmanager.ErrStorageUnavailable}
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+// remoteError Leader 返回的错误，保留可供 errors.Is 判断的原因
+type remoteError struct {
+	message string
+	cause   error
+}
+
+// Error 实现error接口
+func (e *remoteError) Error() string {
+	return e.message
+}
+
+// Unwrap 返回错误原因
+func (e *remoteError) Unwrap() error {
+	return e.cause
+}
+
+// ReadToken 从文件读取转发认证令牌
+func ReadToken(tokenFile string) (string, error) {
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("读取转发令牌失败: %v", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("转发令牌文件 %s 为空", tokenFile)
+	}
+	return token, nil
+}
+
+// authorized 校验请求携带的 Bearer 令牌
+func authorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
@@ -0,0 +1,97 @@
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Language 用户可见消息使用的语言
+type Language string
+
+const (
+	// English 英文
+	English Language = "en"
+	// Chinese 中文
+	Chinese Language = "zh"
+)
+
+// AnnotationLanguage 命名空间上指定该命名空间下请求所用语言的注解
+const AnnotationLanguage = "nodeport-allocator.example.com/language"
+
+var defaultLanguage atomic.Value
+
+func init() {
+	defaultLanguage.Store(Chinese)
+}
+
+// Parse 解析语言标识，支持带地区的写法（如 en-US、zh_CN）
+func Parse(value string) (Language, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if i := strings.IndexAny(value, "-_"); i >= 0 {
+		value = value[:i]
+	}
+	switch Language(value) {
+	case English, Chinese:
+		return Language(value), true
+	}
+	return "", false
+}
+
+// SetDefault 设置请求未指定语言时使用的默认语言
+func SetDefault(lang Language) {
+	defaultLanguage.Store(lang)
+}
+
+// Default 返回默认语言
+func Default() Language {
+	return defaultLanguage.Load().(Language)
+}
+
+type contextKey struct{}
+
+// WithLanguage 返回携带语言的 context
+func WithLanguage(ctx context.Context, lang Language) context.Context {
+	return context.WithValue(ctx, contextKey{}, lang)
+}
+
+// LanguageFromContext 返回 context 中携带的语言，未指定时返回 false
+func LanguageFromContext(ctx context.Context) (Language, bool) {
+	lang, ok := ctx.Value(contextKey{}).(Language)
+	return lang, ok
+}
+
+// FromContext 返回 context 中携带的语言，未指定时返回默认语言
+func FromContext(ctx context.Context) Language {
+	if lang, ok := LanguageFromContext(ctx); ok {
+		return lang
+	}
+	return Default()
+}
+
+// T 按 context 中的语言格式化消息
+func T(ctx context.Context, id MessageID, args ...interface{}) string {
+	return Sprintf(FromContext(ctx), id, args...)
+}
+
+// Errorf 按 context 中的语言格式化错误，格式中可以使用 %w
+func Errorf(ctx context.Context, id MessageID, args ...interface{}) error {
+	return fmt.Errorf(format(FromContext(ctx), id), args...)
+}
+
+// Sprintf 按指定语言格式化消息，缺少该语言的翻译时使用中文
+func Sprintf(lang Language, id MessageID, args ...interface{}) string {
+	return fmt.Sprintf(format(lang, id), args...)
+}
+
+func format(lang Language, id MessageID) string {
+	translations := catalogue[id]
+	if message, ok := translations[lang]; ok {
+		return message
+	}
+	if message, ok := translations[Chinese]; ok {
+		return message
+	}
+	return string(id)
+}
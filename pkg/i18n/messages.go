@@ -0,0 +1,210 @@
+package i18n
+
+// MessageID 消息目录中的消息标识
+type MessageID string
+
+// 分配器消息
+const (
+	RangeLookupFailed       MessageID = "RangeLookupFailed"
+	PreviousRangeFailed     MessageID = "PreviousRangeFailed"
+	RangeNotFound           MessageID = "RangeNotFound"
+	RangeManagerNotFound    MessageID = "RangeManagerNotFound"
+	AllocateFailed          MessageID = "AllocateFailed"
+	AllocateRequestedFailed MessageID = "AllocateRequestedFailed"
+	AutoAllocated           MessageID = "AutoAllocated"
+	RequestedInRange        MessageID = "RequestedInRange"
+	RequestedOutsideRanges  MessageID = "RequestedOutsideRanges"
+	OutsideNamespaceRange   MessageID = "OutsideNamespaceRange"
+	OutsideClusterRange     MessageID = "OutsideClusterRange"
+	PortOutsideRange        MessageID = "PortOutsideRange"
+	PortInUse               MessageID = "PortInUse"
+	RangeExhausted          MessageID = "RangeExhausted"
+)
+
+// 准入消息
+const (
+	DecodeServiceFailed       MessageID = "DecodeServiceFailed"
+	DecodeOldServiceFailed    MessageID = "DecodeOldServiceFailed"
+	LeaderUnavailableAllowed  MessageID = "LeaderUnavailableAllowed"
+	StorageUnavailableAllowed MessageID = "StorageUnavailableAllowed"
+	RehomeDenied              MessageID = "RehomeDenied"
+	RehomeNothingToMove       MessageID = "RehomeNothingToMove"
+	RehomeReallocated         MessageID = "RehomeReallocated"
+	RehomeKept                MessageID = "RehomeKept"
+	NodePortUnassigned        MessageID = "NodePortUnassigned"
+	OutsideEntitledRange      MessageID = "OutsideEntitledRange"
+	OwnerReadFailed           MessageID = "OwnerReadFailed"
+	PortNotRegistered         MessageID = "PortNotRegistered"
+	PortOwnedByOther          MessageID = "PortOwnedByOther"
+)
+
+// 事件消息，事件不属于某个请求，使用默认语言
+const (
+	EventRangeExhausted      MessageID = "EventRangeExhausted"
+	EventOutsideRange        MessageID = "EventOutsideRange"
+	EventOutsideClusterRange MessageID = "EventOutsideClusterRange"
+	EventAllocated           MessageID = "EventAllocated"
+	EventRegistered          MessageID = "EventRegistered"
+	EventRegisterConflict    MessageID = "EventRegisterConflict"
+	EventReleased            MessageID = "EventReleased"
+	EventReleasedUnused      MessageID = "EventReleasedUnused"
+	EventReleaseFailed       MessageID = "EventReleaseFailed"
+	EventNamespaceReleased   MessageID = "EventNamespaceReleased"
+	EventStateCorrupted      MessageID = "EventStateCorrupted"
+)
+
+// catalogue 消息目录，英文翻译中参数顺序与中文不同时使用 %[n] 指定参数
+var catalogue = map[MessageID]map[Language]string{
+	RangeLookupFailed: {
+		Chinese: "获取Service %s/%s 的端口范围失败: %v",
+		English: "failed to determine the port range for Service %s/%s: %v",
+	},
+	PreviousRangeFailed: {
+		Chinese: "获取Service %s/%s 更新前的端口范围失败: %v",
+		English: "failed to determine the previous port range for Service %s/%s: %v",
+	},
+	RangeNotFound: {
+		Chinese: "端口范围 %s 不存在",
+		English: "port range %s does not exist",
+	},
+	RangeManagerNotFound: {
+		Chinese: "端口范围管理器 %s 不存在",
+		English: "port range %s is not initialized",
+	},
+	AllocateFailed: {
+		Chinese: "为端口 %s 分配 NodePort 失败",
+		English: "failed to allocate a NodePort for port %s",
+	},
+	AllocateRequestedFailed: {
+		Chinese: "分配指定 NodePort %d 失败",
+		English: "failed to reserve the requested NodePort %d",
+	},
+	AutoAllocated: {
+		Chinese: "自动分配 NodePort %d (范围: %s)",
+		English: "allocated NodePort %d (range: %s)",
+	},
+	RequestedInRange: {
+		Chinese: "使用指定 NodePort %d (范围: %s)",
+		English: "using the requested NodePort %d (range: %s)",
+	},
+	RequestedOutsideRanges: {
+		Chinese: "使用指定 NodePort %d (不属于任何端口范围)",
+		English: "using the requested NodePort %d (not in any port range)",
+	},
+	OutsideNamespaceRange: {
+		Chinese: "指定的 NodePort %d 超出命名空间 %s 允许的范围 [%d, %d]",
+		English: "the requested NodePort %[1]d is outside the range [%[3]d, %[4]d] allowed for namespace %[2]s",
+	},
+	OutsideClusterRange: {
+		Chinese: "指定的 NodePort %d 超出集群 NodePort 范围 %s",
+		English: "the requested NodePort %d is outside the cluster NodePort range %s",
+	},
+	PortOutsideRange: {
+		Chinese: "端口 %d 超出允许的范围 [%d, %d]",
+		English: "port %d is outside the allowed range [%d, %d]",
+	},
+	PortInUse: {
+		Chinese: "端口 %d 已被使用",
+		English: "port %d is already in use",
+	},
+	RangeExhausted: {
+		Chinese: "端口范围 %s 已满",
+		English: "port range %s has no free ports",
+	},
+	DecodeServiceFailed: {
+		Chinese: "解析Service对象失败: %v",
+		English: "failed to decode the Service: %v",
+	},
+	DecodeOldServiceFailed: {
+		Chinese: "解析旧Service对象失败: %v",
+		English: "failed to decode the previous Service: %v",
+	},
+	LeaderUnavailableAllowed: {
+		Chinese: "NodePort 分配器 Leader 不可达，未分配端口，将由 apiserver 分配: %v",
+		English: "the NodePort allocator leader is unreachable, so no port was reserved and the apiserver will assign one: %v",
+	},
+	StorageUnavailableAllowed: {
+		Chinese: "NodePort 状态存储不可用，未分配端口，将由 apiserver 分配，存储恢复后自动登记: %v",
+		English: "the NodePort state storage is unavailable, so no port was reserved; the apiserver will assign one and it will be registered once storage recovers: %v",
+	},
+	RehomeDenied: {
+		Chinese: "该更新会使 Service 的端口范围从 %s 变为 %s，按 rehomePolicy=%s 拒绝",
+		English: "this update would move the Service from port range %s to %s, which rehomePolicy=%s does not allow",
+	},
+	RehomeNothingToMove: {
+		Chinese: "Service 的端口范围从 %s 变为 %s，现有 NodePort 均无需重新分配",
+		English: "the Service moved from port range %s to %s; none of its NodePorts needs to be reallocated",
+	},
+	RehomeReallocated: {
+		Chinese: "Service 的端口范围从 %s 变为 %s，按 rehomePolicy=%s 在新范围中重新分配 NodePort %s，原端口将在更新生效后回收",
+		English: "the Service moved from port range %s to %s; per rehomePolicy=%s NodePort %s will be reallocated in the new range and the old ports released once the update takes effect",
+	},
+	RehomeKept: {
+		Chinese: "Service 按当前规则应属于端口范围 %s，按 rehomePolicy=%s 继续使用范围 %s 中的端口",
+		English: "the Service now matches port range %s, but per rehomePolicy=%s it keeps using ports from range %s",
+	},
+	NodePortUnassigned: {
+		Chinese: "端口 %s 未分配 NodePort，可能被其他 Webhook 修改",
+		English: "port %s has no NodePort; another webhook may have modified it",
+	},
+	OutsideEntitledRange: {
+		Chinese: "NodePort %d 超出 Service 应属端口范围 %s [%d, %d]",
+		English: "NodePort %d is outside the Service's port range %s [%d, %d]",
+	},
+	OwnerReadFailed: {
+		Chinese: "读取端口 %d 的所属记录失败: %v",
+		English: "failed to read the owner of port %d: %v",
+	},
+	PortNotRegistered: {
+		Chinese: "NodePort %d 未由分配器登记给该 Service",
+		English: "NodePort %d is not registered to this Service by the allocator",
+	},
+	PortOwnedByOther: {
+		Chinese: "NodePort %d 已属于 Service %s",
+		English: "NodePort %d already belongs to Service %s",
+	},
+	EventRangeExhausted: {
+		Chinese: "端口范围 %s 已无可用端口，无法为端口 %s 分配 NodePort",
+		English: "port range %s has no free ports, so no NodePort could be allocated for port %s",
+	},
+	EventOutsideRange: {
+		Chinese: "指定的 NodePort %d 超出范围 %s [%d, %d]",
+		English: "the requested NodePort %d is outside port range %s [%d, %d]",
+	},
+	EventOutsideClusterRange: {
+		Chinese: "指定的 NodePort %d 超出集群 NodePort 范围 %s",
+		English: "the requested NodePort %d is outside the cluster NodePort range %s",
+	},
+	EventAllocated: {
+		Chinese: "已分配 NodePort %s",
+		English: "allocated NodePort %s",
+	},
+	EventRegistered: {
+		Chinese: "存储恢复后已登记 NodePort %s",
+		English: "registered NodePort %s after the state storage recovered",
+	},
+	EventRegisterConflict: {
+		Chinese: "NodePort %d 已登记为 %s 所有，未登记给该 Service",
+		English: "NodePort %d is already registered to %s and was not registered to this Service",
+	},
+	EventReleased: {
+		Chinese: "已回收 NodePort %s",
+		English: "released NodePort %s",
+	},
+	EventReleasedUnused: {
+		Chinese: "已回收不再使用的 NodePort %s",
+		English: "released NodePort %s that is no longer used",
+	},
+	EventReleaseFailed: {
+		Chinese: "端口回收失败，已放行删除，可执行 audit --fix 修正端口状态: %v",
+		English: "failed to release the NodePorts; deletion was allowed to proceed, run audit --fix to correct the port state: %v",
+	},
+	EventNamespaceReleased: {
+		Chinese: "命名空间正在删除，已回收 NodePort %s",
+		English: "the namespace is being deleted; released NodePort %s",
+	},
+	EventStateCorrupted: {
+		Chinese: "端口范围 %s 的状态数据已损坏: %v",
+		English: "the state of port range %s is corrupted: %v",
+	},
+}
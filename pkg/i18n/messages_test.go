@@ -0,0 +1,123 @@
+package i18n
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// verbPattern 匹配格式化动词，可带 %[n] 参数下标
+var verbPattern = regexp.MustCompile(`%(?:\[(\d+)\])?[-+# 0]*\d*(?:\.\d+)?([a-zA-Z%])`)
+
+// argumentVerbs 返回按参数顺序排列的格式化动词
+func argumentVerbs(t *testing.T, format string) []string {
+	t.Helper()
+	var verbs []string
+	next := 0
+	for _, match := range verbPattern.FindAllStringSubmatch(format, -1) {
+		if match[2] == "%" {
+			continue
+		}
+		index := next
+		if match[1] != "" {
+			n, err := strconv.Atoi(match[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			index = n - 1
+		}
+		for len(verbs) <= index {
+			verbs = append(verbs, "")
+		}
+		if verbs[index] != "" && verbs[index] != match[2] {
+			t.Errorf("%q formats argument %d with both %%%s and %%%s", format, index+1, verbs[index], match[2])
+		}
+		verbs[index] = match[2]
+		next = index + 1
+	}
+	return verbs
+}
+
+// declaredMessageIDs 解析 messages.go 中声明的所有 MessageID 常量
+func declaredMessageIDs(t *testing.T) []MessageID {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "messages.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []MessageID
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			if ident, ok := value.Type.(*ast.Ident); !ok || ident.Name != "MessageID" {
+				continue
+			}
+			for _, name := range value.Values {
+				literal, err := strconv.Unquote(name.(*ast.BasicLit).Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, MessageID(literal))
+			}
+		}
+	}
+	return ids
+}
+
+// TestCatalogueComplete 每条消息都有中英文翻译，且两种语言的参数个数与动词一致
+func TestCatalogueComplete(t *testing.T) {
+	declared := declaredMessageIDs(t)
+	if len(declared) != len(catalogue) {
+		t.Errorf("%d message IDs are declared but the catalogue has %d entries", len(declared), len(catalogue))
+	}
+
+	for _, id := range declared {
+		t.Run(string(id), func(t *testing.T) {
+			translations, ok := catalogue[id]
+			if !ok {
+				t.Fatal("missing from the catalogue")
+			}
+			for _, lang := range []Language{Chinese, English} {
+				if translations[lang] == "" {
+					t.Errorf("missing %s translation", lang)
+				}
+			}
+
+			zh := argumentVerbs(t, translations[Chinese])
+			en := argumentVerbs(t, translations[English])
+			if !reflect.DeepEqual(zh, en) {
+				t.Errorf("arguments differ: zh %v, en %v", zh, en)
+			}
+		})
+	}
+}
+
+func TestSprintfFallsBackToChinese(t *testing.T) {
+	tests := []struct {
+		name string
+		lang Language
+		id   MessageID
+		want string
+	}{
+		{name: "english", lang: English, id: PortInUse, want: "port 30000 is already in use"},
+		{name: "chinese", lang: Chinese, id: PortInUse, want: "端口 30000 已被使用"},
+		{name: "unknown language", lang: Language("fr"), id: PortInUse, want: "端口 30000 已被使用"},
+		{name: "unknown message", lang: English, id: MessageID("Unknown"), want: "Unknown%!(EXTRA int=30000)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sprintf(tt.lang, tt.id, 30000); got != tt.want {
+				t.Errorf("Sprintf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -20,6 +21,14 @@ type Election struct {
 	onStopped func()
 	logger    logr.Logger
 	cancel    context.CancelFunc
+
+	identity   string
+	namespace  string
+	allReplica bool // 在所有副本上参与选举，而不依赖 manager 自身的 Leader Election
+
+	mutex    sync.RWMutex
+	leader   string
+	isLeader bool
 }
 
 // NewElection 创建Leader选举管理器
@@ -29,16 +38,44 @@ func NewElection(client kubernetes.Interface, lockName string, onStarted func(co
 		lockName:  lockName,
 		onStarted: onStarted,
 		logger:    logger,
+		identity:  getEnvOrDefault("POD_NAME", "unknown"),
+		namespace: getEnvOrDefault("POD_NAMESPACE", "default"),
 	}
 }
 
+// RunOnAllReplicas 让选举在每个副本上运行，失去 Leader 地位后重新参选，
+// 以便 Follower 也能感知当前 Leader（用于 Leader 转发模式）
+func (e *Election) RunOnAllReplicas() *Election {
+	e.allReplica = true
+	return e
+}
+
+// IsLeader 当前副本是否为 Leader
+func (e *Election) IsLeader() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.isLeader
+}
+
+// LeaderIdentity 当前 Leader 的标识（Pod 名称），未知时返回空字符串
+func (e *Election) LeaderIdentity() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.leader
+}
+
+// Namespace 选举锁所在的命名空间（即当前 Pod 的命名空间）
+func (e *Election) Namespace() string {
+	return e.namespace
+}
+
 // Start 启动Leader选举
 func (e *Election) Start(ctx context.Context) error {
 	e.logger.Info("启动Leader选举", "lockName", e.lockName)
 
 	// 获取当前Pod信息
-	podName := getEnvOrDefault("POD_NAME", "unknown")
-	namespace := getEnvOrDefault("POD_NAMESPACE", "default")
+	podName := e.identity
+	namespace := e.namespace
 
 	// 创建资源锁
 	lock, err := resourcelock.New(
@@ -65,17 +102,20 @@ func (e *Election) Start(ctx context.Context) error {
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
 				e.logger.Info("成为Leader")
+				e.setLeader(podName, true)
 				if e.onStarted != nil {
 					e.onStarted(ctx)
 				}
 			},
 			OnStoppedLeading: func() {
 				e.logger.Info("失去Leader地位")
+				e.setLeader("", false)
 				if e.onStopped != nil {
 					e.onStopped()
 				}
 			},
 			OnNewLeader: func(identity string) {
+				e.setLeader(identity, identity == podName)
 				if identity == podName {
 					return
 				}
@@ -91,6 +131,10 @@ func (e *Election) Start(ctx context.Context) error {
 	// 启动Leader选举
 	go func() {
 		leaderelection.RunOrDie(ctx, config)
+		// 在所有副本上运行时，失去 Leader 地位后继续参选
+		for e.allReplica && ctx.Err() == nil {
+			leaderelection.RunOrDie(ctx, config)
+		}
 	}()
 
 	// 等待上下文取消
@@ -110,7 +154,15 @@ func (e *Election) Stop() error {
 
 // NeedLeaderElection 实现manager.LeaderElectionRunnable接口
 func (e *Election) NeedLeaderElection() bool {
-	return true
+	return !e.allReplica
+}
+
+// setLeader 记录当前 Leader
+func (e *Election) setLeader(identity string, isLeader bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.leader = identity
+	e.isLeader = isLeader
 }
 
 // getEnvOrDefault 获取环境变量或默认值
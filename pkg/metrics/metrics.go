@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// StateCorruptionTotal 检测到端口状态数据损坏的次数
+var StateCorruptionTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "nodeport_allocator_state_corruption_total",
+		Help: "检测到端口状态数据损坏的次数",
+	},
+	[]string{"range"},
+)
+
+func init() {
+	// 注册到 controller-runtime 的 registry，随 metrics 服务一起暴露
+	ctrlmetrics.Registry.MustRegister(StateCorruptionTotal)
+}
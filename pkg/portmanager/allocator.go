@@ -2,10 +2,16 @@ package portmanager
 
 import (
     "context"
+    "errors"
     "fmt"
+    "strings"
 
     "github.com/go-logr/logr"
     corev1 "k8s.io/api/core/v1"
+    "sigs.k8s.io/controller-runtime/pkg/client"
+
+    "github.com/tiggoins/nodeport-allocator/pkg/config"
+    "github.com/tiggoins/nodeport-allocator/pkg/i18n"
 )
 
 // Allocator 端口分配器
@@ -23,33 +29,86 @@ func NewAllocator(manager *Manager, logger logr.Logger) *Allocator {
 }
 
 // AllocateForService 为Service分配端口
-func (a *Allocator) AllocateForService(ctx context.Context, service *corev1.Service) ([]AllocationResult, error) {
+// rangeName 为空时按当前规则匹配端口范围，否则在指定范围中分配（如按 rehomePolicy 保留原范围）
+func (a *Allocator) AllocateForService(ctx context.Context, service *corev1.Service, rangeName string) ([]AllocationResult, error) {
+    if forwarder := a.manager.getForwarder(); forwarder != nil {
+        results, err := forwarder.AllocateForService(ctx, service, rangeName)
+        if err == nil {
+            a.refreshAfterForward(ctx)
+        }
+        return results, err
+    }
+    return a.allocate(ctx, service, rangeName, false)
+}
+
+// PreviewForService 基于内存缓存计算为 Service 分配的端口，不写入存储、不记录事件（用于 dryRun 请求）
+// 缓存可能落后于存储，预览结果不保证与实际创建时的分配一致
+func (a *Allocator) PreviewForService(ctx context.Context, service *corev1.Service, rangeName string) ([]AllocationResult, error) {
+    return a.allocate(ctx, service, rangeName, true)
+}
+
+// allocate 为Service分配端口，dryRun 时只基于内存缓存预选端口
+func (a *Allocator) allocate(ctx context.Context, service *corev1.Service, rangeName string, dryRun bool) ([]AllocationResult, error) {
     namespace := service.Namespace
     if namespace == "" {
         namespace = "default"
     }
 
     // 获取对应的端口范围（优先基于标签，回退到基于namespace）
-    rangeName, portRange, err := a.manager.config.GetPortRangeForService(namespace, service.Labels)
-    if err != nil {
-        return nil, fmt.Errorf("获取Service %s/%s 的端口范围失败: %v", namespace, service.Name, err)
+    var portRange config.PortRange
+    if rangeName == "" {
+        var err error
+        rangeName, portRange, err = a.manager.config.GetPortRangeForService(namespace, service.Labels)
+        if err != nil {
+            return nil, i18n.Errorf(ctx, i18n.RangeLookupFailed, namespace, service.Name, err)
+        }
+    } else {
+        var exists bool
+        if portRange, exists = a.manager.config.PortRanges[rangeName]; !exists {
+            return nil, i18n.Errorf(ctx, i18n.RangeNotFound, rangeName)
+        }
     }
 
     rangeManager := a.manager.GetPortRange(rangeName)
     if rangeManager == nil {
-        return nil, fmt.Errorf("端口范围管理器 %s 不存在", rangeName)
+        return nil, i18n.Errorf(ctx, i18n.RangeManagerNotFound, rangeName)
     }
 
+    owner := OwnerKey(service)
     var results []AllocationResult
+
+    // reserve 占用端口；dryRun 时只在缓存中预选，同一请求中预选过的端口不再重复选择
+    reserve := func(pr *PortRange, requestedPort int32) (int32, bool, error) {
+        return pr.AllocatePort(ctx, requestedPort, owner)
+    }
+    if dryRun {
+        reserved := make(map[int32]bool)
+        reserve = func(pr *PortRange, requestedPort int32) (int32, bool, error) {
+            port, allocated, err := pr.PreviewPort(ctx, requestedPort, owner, reserved)
+            if err == nil {
+                reserved[port] = true
+            }
+            return port, allocated, err
+        }
+    }
+    // rollback 回滚已分配的端口，dryRun 时没有需要回滚的写入
+    rollback := func() {
+        if !dryRun {
+            a.rollbackAllocations(ctx, results)
+        }
+    }
     
     for i, port := range service.Spec.Ports {
         if port.NodePort == 0 {
             // 自动分配端口
-            allocatedPort, err := rangeManager.AllocatePort(ctx, 0)
+            allocatedPort, _, err := reserve(rangeManager, 0)
             if err != nil {
-                // 回滚已分配的端口
-                a.rollbackAllocations(ctx, results)
-                return nil, fmt.Errorf("为端口 %s 分配 NodePort 失败: %v", port.Name, err)
+                rollback()
+                if errors.Is(err, ErrRangeExhausted) {
+                    a.recordRejection(service, dryRun, EventReasonRangeExhausted,
+                        i18n.EventRangeExhausted, rangeName, port.Name)
+                }
+                return nil, atPort(err, i, port.Name, i18n.T(ctx, i18n.AllocateFailed, port.Name))
             }
             
             results = append(results, AllocationResult{
@@ -57,42 +116,82 @@ func (a *Allocator) AllocateForService(ctx context.Context, service *corev1.Serv
                 PortName:      port.Name,
                 AllocatedPort: allocatedPort,
                 RangeName:     rangeName,
-                Message:       fmt.Sprintf("自动分配 NodePort %d (范围: %s)", allocatedPort, rangeName),
+                Strategy:      StrategyFirstFit,
+                Allocated:     true,
+                Message:       i18n.T(ctx, i18n.AutoAllocated, allocatedPort, rangeName),
             })
         } else {
             // 验证指定的端口
-            if port.NodePort < portRange.Start || port.NodePort > portRange.End {
+            targetName, targetRange := rangeName, rangeManager
+            outside := port.NodePort < portRange.Start || port.NodePort > portRange.End
+            if outside {
+                // 已登记为该 Service 所有的端口（如存储不可用时由 apiserver 分配、之后登记的端口）
+                // 无论位于哪个范围都保留，在其所在的范围中确认所属
+                if ownedName, owned := a.manager.PortOwnedBy(ctx, port.NodePort, owner); owned {
+                    targetName, targetRange = ownedName, a.manager.GetPortRange(ownedName)
+                    outside = false
+                }
+            }
+            if outside {
                 // 检查是否允许超出范围的端口
                 if !a.manager.config.AllowOutsideRangePorts {
-                    return nil, fmt.Errorf("指定的 NodePort %d 超出命名空间 %s 允许的范围 [%d, %d]", 
-                        port.NodePort, namespace, portRange.Start, portRange.End)
-                } else {
-                    a.logger.Info("允许使用超出范围的NodePort", 
-                        "port", port.NodePort, 
-                        "namespace", namespace, 
-                        "rangeStart", portRange.Start, 
-                        "rangeEnd", portRange.End)
+                    rollback()
+                    a.recordRejection(service, dryRun, EventReasonOutsideRange,
+                        i18n.EventOutsideRange, port.NodePort, rangeName, portRange.Start, portRange.End)
+                    return nil, &AllocationError{
+                        Reason:    ReasonOutsideRange,
+                        PortIndex: i,
+                        PortName:  port.Name,
+                        Port:      port.NodePort,
+                        Message: i18n.T(ctx, i18n.OutsideNamespaceRange,
+                            port.NodePort, namespace, portRange.Start, portRange.End),
+                    }
                 }
+                // 即使允许超出范围，端口也必须在集群的 NodePort 范围内
+                if !a.manager.config.ClusterNodePortRange.Contains(port.NodePort) {
+                    rollback()
+                    a.recordRejection(service, dryRun, EventReasonOutsideRange,
+                        i18n.EventOutsideClusterRange, port.NodePort, a.manager.config.ClusterNodePortRange)
+                    return nil, &AllocationError{
+                        Reason:    ReasonOutsideRange,
+                        PortIndex: i,
+                        PortName:  port.Name,
+                        Port:      port.NodePort,
+                        Message: i18n.T(ctx, i18n.OutsideClusterRange,
+                            port.NodePort, a.manager.config.ClusterNodePortRange),
+                    }
+                }
+                a.logger.Info("允许使用超出范围的NodePort", 
+                    "port", port.NodePort, 
+                    "namespace", namespace, 
+                    "rangeStart", portRange.Start, 
+                    "rangeEnd", portRange.End)
+                // 端口落在其他范围内时在该范围中占用，避免被重复分配
+                targetName, targetRange = a.manager.rangeContaining(port.NodePort)
             }
             
-            if rangeManager.IsPortUsed(port.NodePort) {
-                return nil, fmt.Errorf("指定的 NodePort %d 已被使用", port.NodePort)
-            }
-            
-            // 分配指定端口
-            _, err := rangeManager.AllocatePort(ctx, port.NodePort)
-            if err != nil {
-                // 回滚已分配的端口
-                a.rollbackAllocations(ctx, results)
-                return nil, fmt.Errorf("分配指定 NodePort %d 失败: %v", port.NodePort, err)
+            message := i18n.T(ctx, i18n.RequestedOutsideRanges, port.NodePort)
+            allocated := false
+            if targetRange != nil {
+                // 分配指定端口（是否已被使用以存储中的最新状态为准，而非本副本的缓存），
+                // 已属于该 Service 的端口（如更新时保留的端口）不视为冲突
+                var err error
+                _, allocated, err = reserve(targetRange, port.NodePort)
+                if err != nil {
+                    rollback()
+                    return nil, atPort(err, i, port.Name, i18n.T(ctx, i18n.AllocateRequestedFailed, port.NodePort))
+                }
+                message = i18n.T(ctx, i18n.RequestedInRange, port.NodePort, targetName)
             }
             
             results = append(results, AllocationResult{
                 PortIndex:     i,
                 PortName:      port.Name,
                 AllocatedPort: port.NodePort,
-                RangeName:     rangeName,
-                Message:       fmt.Sprintf("使用指定 NodePort %d (范围: %s)", port.NodePort, rangeName),
+                RangeName:     targetName,
+                Strategy:      StrategyRequested,
+                Allocated:     allocated,
+                Message:       message,
             })
         }
     }
@@ -102,53 +201,196 @@ func (a *Allocator) AllocateForService(ctx context.Context, service *corev1.Serv
         "range", rangeName,
         "allocated", len(results))
 
+    // 准入时请求可能被后续的 Webhook 拒绝，分配事件由控制器根据 AnnotationUnrecordedAllocation 记录
     return results, nil
 }
 
 // ReleaseForService 释放Service使用的端口
 func (a *Allocator) ReleaseForService(ctx context.Context, service *corev1.Service) error {
+    if forwarder := a.manager.getForwarder(); forwarder != nil {
+        err := forwarder.ReleaseForService(ctx, service)
+        if err == nil {
+            a.refreshAfterForward(ctx)
+        }
+        return err
+    }
+
     namespace := service.Namespace
     if namespace == "" {
         namespace = "default"
     }
 
-    // 获取对应的端口范围（优先基于标签，回退到基于namespace）
-    rangeName, _, err := a.manager.config.GetPortRangeForService(namespace, service.Labels)
+    // Service 已被删除后重建时，保留同名新对象使用的端口
+    keep, err := a.replacementPorts(ctx, service)
     if err != nil {
-        a.logger.Error(err, "获取Service端口范围失败", "namespace", namespace, "service", service.Name)
-        return nil // 不阻塞删除流程
+        return err
     }
 
-    rangeManager := a.manager.GetPortRange(rangeName)
-    if rangeManager == nil {
-        a.logger.Error(fmt.Errorf("端口范围管理器不存在"), "端口范围管理器不存在", "range", rangeName)
-        return nil // 不阻塞删除流程
+    // 按所属记录在所有端口范围中释放，而不是按当前的匹配规则，
+    // 避免标签或配置变更后从错误的范围释放而泄漏端口
+    released, err := a.releaseOwned(ctx, service, keep, true)
+    if err != nil {
+        return err
+    }
+
+    a.logger.Info("端口释放完成",
+        "service", fmt.Sprintf("%s/%s", namespace, service.Name),
+        "released", released)
+
+    return nil
+}
+
+// ReleaseUnusedForService 释放 Service 拥有但已不再使用的端口，
+// 如更新时修改了 NodePort 或 Service 不再是 NodePort 类型
+func (a *Allocator) ReleaseUnusedForService(ctx context.Context, service *corev1.Service) ([]int32, error) {
+    // 先检查缓存，避免每次协调都读取所有范围的存储或转发给 Leader
+    if !a.hasUnkeptOwned(OwnerKey(service), usedPorts(service)) {
+        return nil, nil
+    }
+
+    if forwarder := a.manager.getForwarder(); forwarder != nil {
+        released, err := forwarder.ReleaseUnusedForService(ctx, service)
+        if err == nil {
+            a.refreshAfterForward(ctx)
+        }
+        return released, err
+    }
+
+    // 以 apiserver 中的最新对象为准，避免调用方的缓存落后时误释放刚为更新分配的端口
+    latest := &corev1.Service{}
+    if err := a.manager.reader.Get(ctx, client.ObjectKeyFromObject(service), latest); err != nil {
+        // Service 已删除时由删除流程回收
+        return nil, client.IgnoreNotFound(err)
+    }
+    if latest.UID != service.UID {
+        return nil, nil
     }
 
-    var errors []error
+    return a.releaseOwned(ctx, latest, usedPorts(latest), false)
+}
+
+// replacementPorts 返回替换了 service 的同名新对象使用的端口，
+// 所属记录按 namespace/name 登记，新旧对象共用同一 owner；不存在新对象时返回 nil
+func (a *Allocator) replacementPorts(ctx context.Context, service *corev1.Service) (map[int32]bool, error) {
+    latest := &corev1.Service{}
+    if err := a.manager.reader.Get(ctx, client.ObjectKeyFromObject(service), latest); err != nil {
+        if client.IgnoreNotFound(err) == nil {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("获取Service失败: %v", err)
+    }
+    if latest.UID == service.UID {
+        return nil, nil
+    }
+    return usedPorts(latest), nil
+}
+
+// usedPorts 返回 Service 当前使用的 NodePort，非 NodePort 类型的 Service 不使用任何端口
+func usedPorts(service *corev1.Service) map[int32]bool {
+    ports := make(map[int32]bool)
+    if service.Spec.Type != corev1.ServiceTypeNodePort {
+        return ports
+    }
     for _, port := range service.Spec.Ports {
         if port.NodePort != 0 {
-            if err := rangeManager.ReleasePort(ctx, port.NodePort); err != nil {
-                a.logger.Error(err, "释放端口失败", "port", port.NodePort, "service", fmt.Sprintf("%s/%s", namespace, service.Name))
-                errors = append(errors, err)
+            ports[port.NodePort] = true
+        }
+    }
+    return ports
+}
+
+// ReleaseNamespace 释放命名空间下所有 Service 拥有的端口（如命名空间正在删除），
+// 所有范围在一次存储写入中完成，返回释放的端口
+func (a *Allocator) ReleaseNamespace(ctx context.Context, namespace string) ([]int32, error) {
+    if forwarder := a.manager.getForwarder(); forwarder != nil {
+        released, err := forwarder.ReleaseNamespace(ctx, namespace)
+        if err == nil && len(released) > 0 {
+            a.refreshAfterForward(ctx)
+        }
+        return released, err
+    }
+
+    return a.manager.releaseNamespace(ctx, namespace)
+}
+
+// hasUnkeptOwned 根据缓存判断 owner 是否拥有不在 keep 中的端口
+func (a *Allocator) hasUnkeptOwned(owner string, keep map[int32]bool) bool {
+    for _, name := range a.manager.rangeNames() {
+        for _, port := range a.manager.GetPortRange(name).OwnedPorts(owner) {
+            if !keep[port] {
+                return true
             }
         }
     }
+    return false
+}
 
-    if len(errors) > 0 {
-        return fmt.Errorf("释放 %d 个端口时出现错误", len(errors))
+// releaseOwned 在所有端口范围中释放 Service 拥有且不在 keep 中的端口，keep 为 nil 表示释放全部端口
+// deleted 为 true 表示 Service 已删除，此时 Service 中没有所属记录的端口（旧版本写入）也一并释放
+func (a *Allocator) releaseOwned(ctx context.Context, service *corev1.Service, keep map[int32]bool, deleted bool) ([]int32, error) {
+    owner := OwnerKey(service)
+
+    var unowned []int32
+    if deleted {
+        for _, port := range service.Spec.Ports {
+            if port.NodePort != 0 {
+                unowned = append(unowned, port.NodePort)
+            }
+        }
     }
 
-    a.logger.Info("端口释放完成",
-        "service", fmt.Sprintf("%s/%s", namespace, service.Name),
-        "range", rangeName)
+    var released []int32
+    var errs []error
+    for _, name := range a.manager.rangeNames() {
+        ports, err := a.manager.GetPortRange(name).ReleaseOwned(ctx, owner, unowned, keep)
+        if err != nil {
+            a.logger.Error(err, "释放端口失败", "range", name, "service", owner)
+            errs = append(errs, err)
+            continue
+        }
+        released = append(released, ports...)
+    }
 
-    return nil
+    // 保留底层错误，调用方可据此区分存储不可用等可重试的错误
+    if len(errs) > 0 {
+        return released, fmt.Errorf("释放 %d 个端口范围时出现错误: %w", len(errs), errors.Join(errs...))
+    }
+    return released, nil
+}
+
+// recordEvent 在 Service 上记录事件，未配置 recorder 时忽略
+// 事件对所有查看该 Service 的用户可见，不使用请求的语言，使用配置的默认语言
+func (a *Allocator) recordEvent(service *corev1.Service, eventType, reason string, id i18n.MessageID, args ...interface{}) {
+    if a.manager.recorder == nil {
+        return
+    }
+    a.manager.recorder.Event(service, eventType, reason, i18n.Sprintf(i18n.Default(), id, args...))
+}
+
+// recordRejection 在 Service 上记录分配被拒绝的警告事件，dryRun 请求不记录；
+// 创建被拒绝时 Service 不会被持久化（没有 UID），事件无法出现在 Service 的事件中，也不记录，
+// 拒绝原因已通过准入响应返回给客户端
+func (a *Allocator) recordRejection(service *corev1.Service, dryRun bool, reason string, id i18n.MessageID, args ...interface{}) {
+    if dryRun || service.UID == "" {
+        return
+    }
+    a.recordEvent(service, corev1.EventTypeWarning, reason, id, args...)
+}
+
+// refreshAfterForward 转发成功后从存储刷新本副本的缓存，失败只记录日志
+func (a *Allocator) refreshAfterForward(ctx context.Context) {
+    if err := a.manager.Refresh(ctx); err != nil {
+        a.logger.Error(err, "转发后刷新端口状态失败")
+    }
 }
 
 // rollbackAllocations 回滚端口分配
 func (a *Allocator) rollbackAllocations(ctx context.Context, results []AllocationResult) {
     for _, result := range results {
+        // 更新前已属于该 Service 的端口不回滚
+        if !result.Allocated {
+            continue
+        }
         rangeManager := a.manager.GetPortRange(result.RangeName)
         if rangeManager != nil {
             if err := rangeManager.ReleasePort(ctx, result.AllocatedPort); err != nil {
@@ -158,11 +400,37 @@ func (a *Allocator) rollbackAllocations(ctx context.Context, results []Allocatio
     }
 }
 
+// newlyAllocated 返回本次新分配的端口
+func newlyAllocated(results []AllocationResult) []AllocationResult {
+    var allocated []AllocationResult
+    for _, result := range results {
+        if result.Allocated {
+            allocated = append(allocated, result)
+        }
+    }
+    return allocated
+}
+
+// formatResults 将分配结果格式化为 "端口(范围)" 列表，用于事件与日志
+func formatResults(results []AllocationResult) string {
+    parts := make([]string, 0, len(results))
+    for _, result := range results {
+        if result.RangeName == "" {
+            parts = append(parts, fmt.Sprintf("%d", result.AllocatedPort))
+            continue
+        }
+        parts = append(parts, fmt.Sprintf("%d(%s)", result.AllocatedPort, result.RangeName))
+    }
+    return strings.Join(parts, ", ")
+}
+
 // AllocationResult 分配结果
 type AllocationResult struct {
     PortIndex     int    `json:"port_index"`
     PortName      string `json:"port_name"`
     AllocatedPort int32  `json:"allocated_port"`
     RangeName     string `json:"range_name"`
+    Strategy      string `json:"strategy"`
+    Allocated     bool   `json:"allocated"` // 本次新分配；为 false 表示端口此前已属于该 Service
     Message       string `json:"message"`
 }
@@ -0,0 +1,101 @@
+package portmanager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+// TestReleaseByOwnerAcrossRanges 按所属记录在所有范围中释放端口，与 Service 当前匹配的范围无关
+func TestReleaseByOwnerAcrossRanges(t *testing.T) {
+	// team/web 拥有 team 范围的 30001 以及 default 范围的 30011（如标签变更前分配），
+	// 30003 没有所属记录（旧版本写入），30004 属于其他 Service
+	states := map[string]*RangeState{
+		"team":    testState(t, 30000, 30009, map[int32]string{30001: "team/web", 30003: "", 30004: "team/other"}),
+		"default": testState(t, 30010, 30019, map[int32]string{30011: "team/web"}),
+	}
+	web := testService("team", "web", 30001, 30003)
+
+	replacement := testService("team", "web", 30011)
+	replacement.UID = types.UID("uid-replacement")
+
+	tests := []struct {
+		name string
+		// cluster 集群中现有的 Service
+		cluster []client.Object
+		release func(ctx context.Context, a *Allocator) ([]int32, error)
+		want    map[string][]int32
+	}{
+		{
+			name: "deleted service",
+			release: func(ctx context.Context, a *Allocator) ([]int32, error) {
+				return nil, a.ReleaseForService(ctx, web)
+			},
+			want: map[string][]int32{"team": {30004}, "default": nil},
+		},
+		{
+			name:    "recreated with the same name",
+			cluster: []client.Object{replacement},
+			release: func(ctx context.Context, a *Allocator) ([]int32, error) {
+				return nil, a.ReleaseForService(ctx, web)
+			},
+			want: map[string][]int32{"team": {30004}, "default": {30011}},
+		},
+		{
+			name:    "unused after update",
+			cluster: []client.Object{testService("team", "web", 30001, 30003)},
+			release: func(ctx context.Context, a *Allocator) ([]int32, error) {
+				return a.ReleaseUnusedForService(ctx, web)
+			},
+			want: map[string][]int32{"team": {30001, 30003, 30004}, "default": nil},
+		},
+		{
+			name: "unused after type change",
+			cluster: []client.Object{func() client.Object {
+				service := testService("team", "web", 30001, 30003)
+				service.Spec.Type = corev1.ServiceTypeClusterIP
+				return service
+			}()},
+			release: func(ctx context.Context, a *Allocator) ([]int32, error) {
+				return a.ReleaseUnusedForService(ctx, web)
+			},
+			// 没有所属记录的 30003 只在 Service 删除时释放
+			want: map[string][]int32{"team": {30003, 30004}, "default": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			objs := append([]client.Object{stateConfigMap(t, testStateName, states)}, tt.cluster...)
+			c := newFakeClient(t, nil, objs...)
+			manager := newTestManager(t, c, testConfig(config.CorruptionPolicyFail, config.HAModeCAS))
+			if err := manager.Initialize(ctx); err != nil {
+				t.Fatalf("Initialize: %v", err)
+			}
+
+			if _, err := tt.release(ctx, manager.GetAllocator()); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+
+			for rangeName, want := range tt.want {
+				portRange := manager.GetPortRange(rangeName)
+				if got := portRange.bitSet.Ports(); !reflect.DeepEqual(got, want) {
+					t.Errorf("range %s cached ports = %v, want %v", rangeName, got, want)
+				}
+				stored, err := manager.storage.LoadState(ctx, rangeName, portRange.config.Start, portRange.config.End)
+				if err != nil {
+					t.Fatalf("LoadState: %v", err)
+				}
+				if got := stored.BitSet.Ports(); !reflect.DeepEqual(got, want) {
+					t.Errorf("range %s stored ports = %v, want %v", rangeName, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package portmanager
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 记录在 Service 上的分配元数据注解
+const (
+	// AnnotationRange 分配时 Service 所属的端口范围
+	AnnotationRange = "nodeport-allocator.example.com/range"
+	// AnnotationRangeDescription 端口范围的描述
+	AnnotationRangeDescription = "nodeport-allocator.example.com/range-description"
+	// AnnotationStrategy 分配策略
+	AnnotationStrategy = "nodeport-allocator.example.com/strategy"
+	// AnnotationAllocatedAt 分配时间（RFC3339）
+	AnnotationAllocatedAt = "nodeport-allocator.example.com/allocated-at"
+	// AnnotationPendingRegistration 存储不可用时按 failurePolicy=Ignore 放行的 Service，
+	// 端口由 apiserver 分配，尚未登记到存储中，由控制器在存储恢复后登记并移除该注解
+	AnnotationPendingRegistration = "nodeport-allocator.example.com/pending-registration"
+	// AnnotationUnrecordedAllocation 本次新分配、尚未记录 NodePortAllocated 事件的端口，
+	// 准入时 Service 可能尚未持久化，由控制器记录事件后移除该注解
+	AnnotationUnrecordedAllocation = "nodeport-allocator.example.com/unrecorded-allocation"
+)
+
+// 分配策略
+const (
+	// StrategyFirstFit 自动分配范围内第一个空闲端口
+	StrategyFirstFit = "first-fit"
+	// StrategyRequested 使用 Service 中指定的端口
+	StrategyRequested = "requested"
+	// StrategyMixed 同一 Service 中既有自动分配也有指定端口
+	StrategyMixed = "mixed"
+)
+
+// AllocationAnnotations 根据分配结果生成需要记录在 Service 上的注解，rangeName 为 Service 使用的端口范围
+func (m *Manager) AllocationAnnotations(rangeName string, results []AllocationResult, now time.Time) (map[string]string, error) {
+	portRange, exists := m.config.PortRanges[rangeName]
+	if !exists {
+		return nil, fmt.Errorf("端口范围 %s 不存在", rangeName)
+	}
+
+	annotations := map[string]string{
+		AnnotationRange:       rangeName,
+		AnnotationStrategy:    ResultsStrategy(results),
+		AnnotationAllocatedAt: now.UTC().Format(time.RFC3339),
+	}
+	if portRange.Description != "" {
+		annotations[AnnotationRangeDescription] = portRange.Description
+	}
+	return annotations, nil
+}
+
+// FormatAllocated 将本次新分配的端口格式化为 "端口(范围)" 列表，没有新分配的端口时返回空字符串
+func FormatAllocated(results []AllocationResult) string {
+	allocated := newlyAllocated(results)
+	if len(allocated) == 0 {
+		return ""
+	}
+	return formatResults(allocated)
+}
+
+// ResultsStrategy 汇总分配结果的策略，各端口策略不同时为 mixed
+func ResultsStrategy(results []AllocationResult) string {
+	strategy := ""
+	for _, result := range results {
+		switch {
+		case strategy == "":
+			strategy = result.Strategy
+		case strategy != result.Strategy:
+			strategy = StrategyMixed
+		}
+	}
+	return strategy
+}
+
+// serviceNamespace 返回 Service 的命名空间，为空时视为 default
+func serviceNamespace(service *corev1.Service) string {
+	if service.Namespace == "" {
+		return "default"
+	}
+	return service.Namespace
+}
@@ -0,0 +1,100 @@
+package portmanager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DenialReason 端口分配被拒绝的原因
+type DenialReason string
+
+const (
+	// ReasonRangeExhausted 端口范围中已无可用端口
+	ReasonRangeExhausted DenialReason = "RangeExhausted"
+	// ReasonPortInUse 指定的端口已被其他 Service 使用
+	ReasonPortInUse DenialReason = "PortInUse"
+	// ReasonOutsideRange 指定的端口超出允许的范围
+	ReasonOutsideRange DenialReason = "OutsideRange"
+	// ReasonStorageUnavailable 端口状态存储暂时不可用，可重试
+	ReasonStorageUnavailable DenialReason = "StorageUnavailable"
+)
+
+// 与各拒绝原因对应的错误值，可通过 errors.Is 判断
+var (
+	// ErrRangeExhausted 端口范围中已无可用端口
+	ErrRangeExhausted = errors.New("端口范围已满")
+	// ErrPortInUse 端口已被使用
+	ErrPortInUse = errors.New("端口已被使用")
+	// ErrOutsideRange 端口超出允许的范围
+	ErrOutsideRange = errors.New("端口超出允许的范围")
+	// ErrStorageUnavailable 端口状态存储不可用
+	ErrStorageUnavailable = errors.New("端口状态存储不可用")
+)
+
+var reasonErrors = map[DenialReason]error{
+	ReasonRangeExhausted:     ErrRangeExhausted,
+	ReasonPortInUse:          ErrPortInUse,
+	ReasonOutsideRange:       ErrOutsideRange,
+	ReasonStorageUnavailable: ErrStorageUnavailable,
+}
+
+// AllocationError 带有拒绝原因和端口位置的分配错误，可序列化后经 Leader 转发返回
+type AllocationError struct {
+	Reason DenialReason `json:"reason"`
+	// PortIndex 出错的端口在 spec.ports 中的下标，-1 表示不针对某个端口
+	PortIndex int    `json:"portIndex"`
+	PortName  string `json:"portName,omitempty"`
+	// Port 出错的 NodePort，自动分配时为 0
+	Port    int32  `json:"port,omitempty"`
+	Message string `json:"message"`
+}
+
+// newAllocationError 创建不针对具体端口的分配错误
+func newAllocationError(reason DenialReason, port int32, message string) *AllocationError {
+	return &AllocationError{
+		Reason:    reason,
+		PortIndex: -1,
+		Port:      port,
+		Message:   message,
+	}
+}
+
+// Error 实现error接口
+func (e *AllocationError) Error() string {
+	return e.Message
+}
+
+// Is 使 errors.Is 可以按拒绝原因判断，如 errors.Is(err, ErrRangeExhausted)
+func (e *AllocationError) Is(target error) bool {
+	return reasonErrors[e.Reason] == target
+}
+
+// Field 出错字段的路径，如 spec.ports[1].nodePort
+func (e *AllocationError) Field() string {
+	if e.PortIndex < 0 {
+		return "spec.ports"
+	}
+	return fmt.Sprintf("spec.ports[%d].nodePort", e.PortIndex)
+}
+
+// atPort 将错误定位到 spec.ports 中的第 index 个端口，并在消息前添加上下文
+// 无法识别原因的错误保留原始错误链返回
+func atPort(err error, index int, portName, message string) error {
+	var allocationErr *AllocationError
+	if errors.As(err, &allocationErr) {
+		located := *allocationErr
+		located.PortIndex = index
+		located.PortName = portName
+		located.Message = fmt.Sprintf("%s: %s", message, allocationErr.Message)
+		return &located
+	}
+	if errors.Is(err, ErrStorageUnavailable) {
+		return &AllocationError{
+			Reason:    ReasonStorageUnavailable,
+			PortIndex: index,
+			PortName:  portName,
+			Message:   fmt.Sprintf("%s: %v", message, err),
+		}
+	}
+	return fmt.Errorf("%s: %w", message, err)
+}
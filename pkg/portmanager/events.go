@@ -0,0 +1,19 @@
+package portmanager
+
+// Service 上记录的事件原因
+const (
+	// EventReasonAllocated 已为 Service 分配 NodePort
+	EventReasonAllocated = "NodePortAllocated"
+	// EventReasonReleased 已回收 Service 使用的 NodePort
+	EventReasonReleased = "NodePortReleased"
+	// EventReasonReleaseFailed 回收端口失败，Service 删除已放行
+	EventReasonReleaseFailed = "NodePortReleaseFailed"
+	// EventReasonRangeExhausted 端口范围已满，分配被拒绝
+	EventReasonRangeExhausted = "NodePortRangeExhausted"
+	// EventReasonRegistered 存储恢复后已登记 apiserver 分配的 NodePort
+	EventReasonRegistered = "NodePortRegistered"
+	// EventReasonRegisterConflict 登记 apiserver 分配的 NodePort 时端口已登记为其他 Service 所有
+	EventReasonRegisterConflict = "NodePortRegisterConflict"
+	// EventReasonOutsideRange 指定的 NodePort 超出允许的范围，分配被拒绝
+	EventReasonOutsideRange = "NodePortOutsideRange"
+)
@@ -0,0 +1,27 @@
+package portmanager
+
+import (
+	"context"
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ErrLeaderUnavailable Leader 不可达，转发失败
+var ErrLeaderUnavailable = errors.New("Leader 不可达")
+
+// Forwarder Leader 转发模式下，将端口分配/释放请求转发给 Leader 执行
+type Forwarder interface {
+	// ShouldForward 当前副本不是 Leader、需要转发时返回 true
+	ShouldForward() bool
+	// AllocateForService 在 Leader 上为 Service 分配端口
+	AllocateForService(ctx context.Context, service *corev1.Service, rangeName string) ([]AllocationResult, error)
+	// ReleaseForService 在 Leader 上释放 Service 使用的端口
+	ReleaseForService(ctx context.Context, service *corev1.Service) error
+	// ReleaseUnusedForService 在 Leader 上释放 Service 拥有但已不再使用的端口
+	ReleaseUnusedForService(ctx context.Context, service *corev1.Service) ([]int32, error)
+	// ReleaseNamespace 在 Leader 上释放命名空间下所有 Service 拥有的端口
+	ReleaseNamespace(ctx context.Context, namespace string) ([]int32, error)
+	// RegisterServicePorts 在 Leader 上登记由 apiserver 分配的端口
+	RegisterServicePorts(ctx context.Context, service *corev1.Service) ([]int32, map[int32]string, error)
+}
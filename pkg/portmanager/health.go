@@ -0,0 +1,62 @@
+package portmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+// storagePingTimeout 就绪检查访问存储的超时时间
+const storagePingTimeout = 3 * time.Second
+
+// lockStuckThreshold 端口范围的写锁持有超过该时长即视为卡住，
+// 正常的分配与释放（含存储冲突重试）远小于该值
+const lockStuckThreshold = 2 * time.Minute
+
+// MarkStateLoaded 标记端口状态已加载完成（Initialize 以及扫描现有 Services 之后）
+func (m *Manager) MarkStateLoaded() {
+	m.stateLoaded.Store(true)
+}
+
+// StateReadyCheck 就绪检查：端口状态加载完成前，或有端口范围的状态已损坏、仍在等待 Leader 重建时返回错误，
+// 避免以空状态处理分配与校验请求
+func (m *Manager) StateReadyCheck(_ *http.Request) error {
+	if !m.stateLoaded.Load() {
+		return errors.New("端口状态尚未加载完成")
+	}
+	for _, name := range m.rangeNames() {
+		if m.GetPortRange(name).RebuildPending() {
+			return fmt.Errorf("端口范围 %s 的状态已损坏，等待 Leader 重建", name)
+		}
+	}
+	return nil
+}
+
+// LivenessCheck 存活检查：端口范围的写锁持有时间过长（如存储请求挂起）时返回错误，
+// 由 kubelet 重启容器，避免所有分配请求阻塞在该锁上
+func (m *Manager) LivenessCheck(_ *http.Request) error {
+	for _, name := range m.rangeNames() {
+		if held := m.GetPortRange(name).lockHeldFor(); held > lockStuckThreshold {
+			return fmt.Errorf("端口范围 %s 的锁已被持有 %s", name, held.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// StorageReadyCheck 就绪检查：存储不可访问时返回错误，使 apiserver 不再将请求发往该副本
+// 存储 failurePolicy 为 Ignore 时副本在存储不可用时仍放行请求（降级模式），只记录日志，不影响就绪状态
+func (m *Manager) StorageReadyCheck(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), storagePingTimeout)
+	defer cancel()
+
+	err := m.storage.Ping(ctx)
+	if err != nil && m.config.StorageConfig.FailurePolicy == config.FailurePolicyIgnore {
+		m.logger.Error(err, "存储不可用，按failurePolicy=Ignore以降级模式继续服务")
+		return nil
+	}
+	return err
+}
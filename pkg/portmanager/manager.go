@@ -2,11 +2,16 @@ package portmanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/tiggoins/nodeport-allocator/pkg/config"
@@ -16,28 +21,38 @@ import (
 type Manager struct {
 	ctx       context.Context
 	client    client.Client
+	reader    client.Reader
 	config    *config.Config
 	storage   *Storage
 	ranges    map[string]*PortRange
 	allocator *Allocator
+	forwarder Forwarder
+	recorder  record.EventRecorder
 	logger    logr.Logger
 	mutex     sync.RWMutex
+
+	// stateLoaded 端口状态是否已加载完成，用于就绪检查
+	stateLoaded atomic.Bool
 }
 
 // NewManager 创建新的端口管理器
-func NewManager(ctx context.Context, client client.Client, config *config.Config, logger logr.Logger) (*Manager, error) {
-	storage, err := NewStorage(client, &config.StorageConfig, logger.WithName("storage"))
+// reader 应直连 apiserver（如 mgr.GetAPIReader()），保证读取到的存储状态是最新的；
+// recorder 用于上报状态损坏以及 Service 上的分配事件，可为 nil
+func NewManager(ctx context.Context, client client.Client, reader client.Reader, config *config.Config, recorder record.EventRecorder, logger logr.Logger) (*Manager, error) {
+	storage, err := NewStorage(client, reader, &config.StorageConfig, recorder, logger.WithName("storage"))
 	if err != nil {
 		return nil, fmt.Errorf("创建存储失败: %v", err)
 	}
 
 	manager := &Manager{
-		ctx:     ctx,
-		client:  client,
-		config:  config,
-		storage: storage,
-		ranges:  make(map[string]*PortRange),
-		logger:  logger,
+		ctx:      ctx,
+		client:   client,
+		reader:   reader,
+		config:   config,
+		storage:  storage,
+		ranges:   make(map[string]*PortRange),
+		recorder: recorder,
+		logger:   logger,
 	}
 
 	manager.allocator = NewAllocator(manager, logger.WithName("allocator"))
@@ -45,7 +60,10 @@ func NewManager(ctx context.Context, client client.Client, config *config.Config
 	return manager, nil
 }
 
-// Initialize 初始化端口管理器
+// Initialize 初始化端口管理器，同时校验存储中的状态数据
+// 状态损坏时按 corruptionPolicy 处理：fail 返回错误拒绝启动，rebuild 清空该范围，
+// 由随后的 ScanExistingServices 根据集群中的 Services 重建；
+// Leader 转发模式下只有 Leader 修改端口状态，清空推迟到当选 Leader 后的 RebuildCorrupted，本副本暂用空缓存
 func (m *Manager) Initialize(ctx context.Context) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -53,7 +71,22 @@ func (m *Manager) Initialize(ctx context.Context) error {
 	for name, rangeConfig := range m.config.PortRanges {
 		portRange := NewPortRange(name, rangeConfig, m.storage, m.logger)
 		if err := portRange.Initialize(ctx); err != nil {
-			return fmt.Errorf("初始化端口范围 %s 失败: %v", name, err)
+			var corruption *StateCorruptionError
+			if !errors.As(err, &corruption) || m.config.StorageConfig.CorruptionPolicy != config.CorruptionPolicyRebuild {
+				return fmt.Errorf("初始化端口范围 %s 失败: %w", name, err)
+			}
+
+			if m.config.HighAvailability.Mode == config.HAModeLeader {
+				m.logger.Info("端口范围状态已损坏，待当选Leader后清空并从现有Services重建", "range", name)
+				portRange.resetCache()
+				m.ranges[name] = portRange
+				continue
+			}
+
+			m.logger.Info("端口范围状态已损坏，按策略清空后从现有Services重建", "range", name)
+			if err := portRange.Reset(ctx, nil); err != nil {
+				return fmt.Errorf("重置端口范围 %s 失败: %w", name, err)
+			}
 		}
 		m.ranges[name] = portRange
 	}
@@ -62,6 +95,95 @@ func (m *Manager) Initialize(ctx context.Context) error {
 	return nil
 }
 
+// RebuildCorrupted 重建初始化时状态已损坏、仍在等待重建的端口范围，由新 Leader 在扫描现有 Services 之前调用
+// 重建在一次写入中标记集群中 NodePort Services 使用的端口，其他副本不会加载到中间的空状态；
+// 其他 Leader 已完成重建（状态可以正常加载）的范围不再重建
+func (m *Manager) RebuildCorrupted(ctx context.Context) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var services *corev1.ServiceList
+	for name, portRange := range m.ranges {
+		if !portRange.RebuildPending() {
+			continue
+		}
+		err := portRange.Refresh(ctx)
+		var corruption *StateCorruptionError
+		if err != nil && !errors.As(err, &corruption) {
+			return fmt.Errorf("刷新端口范围 %s 失败: %v", name, err)
+		}
+		if err == nil {
+			continue
+		}
+
+		if services == nil {
+			services = &corev1.ServiceList{}
+			if err := m.reader.List(ctx, services); err != nil {
+				return fmt.Errorf("列出Services失败: %v", err)
+			}
+		}
+		m.logger.Info("端口范围状态已损坏，按策略从现有Services重建", "range", name)
+		if err := portRange.Reset(ctx, markServicePorts(portRange, services.Items)); err != nil {
+			return fmt.Errorf("重置端口范围 %s 失败: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// markServicePorts 返回将 services 中位于 portRange 内的 NodePort 登记为其 Service 所有的修改
+// 按端口数值而非应属范围登记，宁可多标记也不把使用中的端口当作空闲；之后的扫描按常规规则补齐
+func markServicePorts(portRange *PortRange, services []corev1.Service) StateMutation {
+	return func(state *RangeState) (bool, error) {
+		for i := range services {
+			service := &services[i]
+			if service.Spec.Type != corev1.ServiceTypeNodePort {
+				continue
+			}
+			for _, port := range service.Spec.Ports {
+				if port.NodePort == 0 || !portRange.Contains(port.NodePort) {
+					continue
+				}
+				if err := state.Set(port.NodePort, OwnerKey(service)); err != nil {
+					return false, err
+				}
+			}
+		}
+		return true, nil
+	}
+}
+
+// Refresh 从存储重新加载所有端口范围的位图
+// 某个范围失败（如状态已损坏）时继续刷新其他范围，返回所有失败
+func (m *Manager) Refresh(ctx context.Context) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var errs []error
+	for name, portRange := range m.ranges {
+		if err := portRange.Refresh(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("刷新端口范围 %s 失败: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetForwarder 设置 Leader 转发器，设置后非 Leader 副本的分配/释放请求将转发给 Leader
+func (m *Manager) SetForwarder(forwarder Forwarder) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.forwarder = forwarder
+}
+
+// getForwarder 获取需要转发时使用的转发器，本副本应直接处理时返回 nil
+func (m *Manager) getForwarder() Forwarder {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.forwarder == nil || !m.forwarder.ShouldForward() {
+		return nil
+	}
+	return m.forwarder
+}
+
 // GetPortRange 获取端口范围管理器
 func (m *Manager) GetPortRange(name string) *PortRange {
 	m.mutex.RLock()
@@ -69,6 +191,117 @@ func (m *Manager) GetPortRange(name string) *PortRange {
 	return m.ranges[name]
 }
 
+// IsStateObject 判断ConfigMap是否为端口状态存储对象
+func (m *Manager) IsStateObject(namespace, name string) bool {
+	return m.storage.IsStateObject(namespace, name)
+}
+
+// rangeContaining 返回端口数值所在的端口范围，不属于任何范围时返回 nil
+func (m *Manager) rangeContaining(port int32) (string, *PortRange) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for name, portRange := range m.ranges {
+		if portRange.Contains(port) {
+			return name, portRange
+		}
+	}
+	return "", nil
+}
+
+// PortOwner 返回端口所在的端口范围及其所属 Service，端口不属于任何范围时返回空的范围名称
+// fresh 为 true 时先从存储刷新该范围，读取到其他副本的最新写入
+func (m *Manager) PortOwner(ctx context.Context, port int32, fresh bool) (string, string, error) {
+	rangeName, portRange := m.rangeContaining(port)
+	if portRange == nil {
+		return "", "", nil
+	}
+	if fresh {
+		if err := portRange.Refresh(ctx); err != nil {
+			return "", "", err
+		}
+	}
+	return rangeName, portRange.OwnerOf(port), nil
+}
+
+// rangeNames 按名称排序的端口范围列表
+func (m *Manager) rangeNames() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	names := make([]string, 0, len(m.ranges))
+	for name := range m.ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Owners 返回内存缓存中拥有端口的所有 owner（namespace/name），已排序
+func (m *Manager) Owners() []string {
+	seen := make(map[string]bool)
+	var owners []string
+	for _, name := range m.rangeNames() {
+		for _, owner := range m.GetPortRange(name).Owners() {
+			if !seen[owner] {
+				seen[owner] = true
+				owners = append(owners, owner)
+			}
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// releaseNamespace 在一次存储写入中（分片时每个分片一次）释放命名空间下所有 Service 拥有的端口
+func (m *Manager) releaseNamespace(ctx context.Context, namespace string) ([]int32, error) {
+	names := m.rangeNames()
+	portRanges := make(map[string]*PortRange, len(names))
+	configs := make(map[string]config.PortRange, len(names))
+	for _, name := range names {
+		portRange := m.GetPortRange(name)
+		portRanges[name] = portRange
+		configs[name] = portRange.config
+	}
+
+	// 按名称顺序锁定所有范围，写入期间暂停这些范围上的分配
+	for _, name := range names {
+		portRanges[name].lock()
+		defer portRanges[name].unlock()
+	}
+
+	prefix := namespace + "/"
+	released := make(map[string][]int32)
+	stored, err := m.storage.UpdateStates(ctx, configs, func(rangeName string, state *RangeState) (bool, error) {
+		var ports []int32
+		for port, owner := range state.Owners {
+			if strings.HasPrefix(owner, prefix) {
+				ports = append(ports, port)
+			}
+		}
+		for _, port := range ports {
+			if err := state.Clear(port); err != nil {
+				return false, fmt.Errorf("清除端口标记失败: %v", err)
+			}
+		}
+		released[rangeName] = ports
+		return len(ports) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("释放命名空间 %s 的端口失败: %v", namespace, err)
+	}
+
+	var ports []int32
+	for name, state := range stored {
+		portRanges[name].setState(state)
+		ports = append(ports, released[name]...)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	m.logger.Info("命名空间端口释放完成", "namespace", namespace, "released", len(ports))
+	return ports, nil
+}
+
 // GetAllocator 获取端口分配器
 func (m *Manager) GetAllocator() *Allocator {
 	return m.allocator
@@ -85,7 +318,7 @@ func (m *Manager) ScanExistingServices(ctx context.Context) error {
 
 	// 列出所有Services
 	var serviceList corev1.ServiceList
-	if err := m.client.List(ctx, &serviceList); err != nil {
+	if err := m.reader.List(ctx, &serviceList); err != nil {
 		return fmt.Errorf("列出Services失败: %v", err)
 	}
 
@@ -112,14 +345,14 @@ func (m *Manager) ScanExistingServices(ctx context.Context) error {
 			continue
 		}
 
-		rangeManager := m.GetPortRange(rangeName)
-		if rangeManager == nil {
-			m.logger.Error(fmt.Errorf("端口范围管理器不存在"), "端口范围管理器不存在", "range", rangeName)
-			continue
-		}
+		owner := OwnerKey(&service)
 
 		// 标记已使用的端口
 		for _, port := range service.Spec.Ports {
+			if port.NodePort == 0 {
+				continue
+			}
+
 			// 业务逻辑层检查：验证现有Service的端口是否在配置范围内
 			// 这在启动时扫描现有服务时使用，以确保端口状态与实际集群状态一致
 			if port.NodePort < portRange.Start || port.NodePort > portRange.End {
@@ -144,8 +377,14 @@ func (m *Manager) ScanExistingServices(ctx context.Context) error {
 				}
 			}
 
+			// 端口按数值所在的范围标记，不属于任何范围的端口无需跟踪
+			_, rangeManager := m.rangeContaining(port.NodePort)
+			if rangeManager == nil {
+				continue
+			}
+
 			// 标记端口为已使用
-			if err := rangeManager.MarkPortAsUsed(ctx, port.NodePort); err != nil {
+			if err := rangeManager.MarkPortAsUsed(ctx, port.NodePort, owner); err != nil {
 				m.logger.Error(err, "标记端口为已使用失败",
 					"namespace", namespace,
 					"name", service.Name,
@@ -163,19 +402,61 @@ func (m *Manager) ScanExistingServices(ctx context.Context) error {
 	return nil
 }
 
-// ValidatePortForService 验证Service的端口是否合法（支持标签）
-func (m *Manager) ValidatePortForService(namespace string, labels map[string]string, port int32) error {
-	_, portRange, err := m.config.GetPortRangeForService(namespace, labels)
-	if err != nil {
-		return err
+// PendingRegistration 判断 Service 的端口是否由 apiserver 分配、尚待登记
+func PendingRegistration(service *corev1.Service) bool {
+	_, exists := service.Annotations[AnnotationPendingRegistration]
+	return exists
+}
+
+// RegisterServicePorts 将 Service 当前使用的 NodePort 登记为该 Service 所有，返回登记的端口，
+// 以及已登记为其他 Service 所有、未被覆盖的端口与其所属 Service
+// 用于存储不可用时由 apiserver 分配的端口；端口按数值所在的范围登记，不属于任何范围的端口无需跟踪
+func (m *Manager) RegisterServicePorts(ctx context.Context, service *corev1.Service) ([]int32, map[int32]string, error) {
+	if forwarder := m.getForwarder(); forwarder != nil {
+		registered, conflicts, err := forwarder.RegisterServicePorts(ctx, service)
+		if err == nil && len(registered) > 0 {
+			m.allocator.refreshAfterForward(ctx)
+		}
+		return registered, conflicts, err
 	}
 
-	if port < portRange.Start || port > portRange.End {
-		// 检查是否允许超出范围的端口
-		if !m.config.AllowOutsideRangePorts {
-			return fmt.Errorf("端口 %d 超出允许的范围 [%d, %d]", port, portRange.Start, portRange.End)
+	owner := OwnerKey(service)
+
+	var registered []int32
+	conflicts := make(map[int32]string)
+	for _, port := range service.Spec.Ports {
+		if port.NodePort == 0 {
+			continue
+		}
+		_, rangeManager := m.rangeContaining(port.NodePort)
+		if rangeManager == nil {
+			continue
+		}
+		current, err := rangeManager.RegisterPort(ctx, port.NodePort, owner)
+		if err != nil {
+			return registered, conflicts, fmt.Errorf("登记端口 %d 失败: %w", port.NodePort, err)
 		}
+		if current != "" && current != owner {
+			conflicts[port.NodePort] = current
+			continue
+		}
+		registered = append(registered, port.NodePort)
 	}
+	return registered, conflicts, nil
+}
 
-	return nil
+// PortOwnedBy 判断端口是否已登记为 owner 所有，返回端口所在的范围
+// 缓存中不属于 owner 时从存储刷新后再判断，读取到其他副本（如控制器登记）的最新写入
+func (m *Manager) PortOwnedBy(ctx context.Context, port int32, owner string) (string, bool) {
+	rangeName, current, err := m.PortOwner(ctx, port, false)
+	if rangeName == "" || err != nil {
+		return "", false
+	}
+	if current != owner {
+		if _, current, err = m.PortOwner(ctx, port, true); err != nil {
+			m.logger.Error(err, "刷新端口状态失败", "port", port)
+			return "", false
+		}
+	}
+	return rangeName, current == owner
 }
@@ -0,0 +1,193 @@
+package portmanager
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+// testConfig 测试使用的配置：team 命名空间使用 team 范围，其他命名空间使用 default 范围
+func testConfig(corruptionPolicy, mode string) *config.Config {
+	cfg := &config.Config{
+		PortRanges: map[string]config.PortRange{
+			"team":    {Start: 30000, End: 30009, Namespaces: []string{"team"}},
+			"default": {Start: 30010, End: 30019, Namespaces: []string{"*"}},
+		},
+		DefaultRange:         "default",
+		ClusterNodePortRange: config.DefaultNodePortRange,
+		RehomePolicy:         config.RehomePolicyKeep,
+		StorageConfig:        testStorageConfig(0),
+		HighAvailability:     config.HighAvailability{Mode: mode},
+	}
+	cfg.StorageConfig.CorruptionPolicy = corruptionPolicy
+	return cfg
+}
+
+// newTestManager 创建基于 c 的端口管理器，不执行 Initialize
+func newTestManager(t *testing.T, c client.Client, cfg *config.Config) *Manager {
+	t.Helper()
+	manager, err := NewManager(context.Background(), c, c, cfg, nil, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+// testService 创建指定 NodePort 的 NodePort 类型 Service
+func testService(namespace, name string, nodePorts ...int32) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID("uid-" + name)},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	}
+	for i, nodePort := range nodePorts {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Port:     int32(80 + i),
+			NodePort: nodePort,
+		})
+	}
+	return service
+}
+
+func TestInitializeCorruptionPolicy(t *testing.T) {
+	valid := testState(t, 30000, 30009, map[int32]string{30001: "team/web"})
+
+	tests := []struct {
+		name        string
+		policy      string
+		mode        string
+		corrupted   bool
+		wantErr     bool
+		wantPending bool
+		// wantStored 初始化后存储中 team 范围的所属记录，nil 表示数据仍为损坏状态
+		wantStored map[int32]string
+	}{
+		{
+			name:       "valid state",
+			policy:     config.CorruptionPolicyFail,
+			mode:       config.HAModeCAS,
+			wantStored: map[int32]string{30001: "team/web"},
+		},
+		{
+			name:      "fail",
+			policy:    config.CorruptionPolicyFail,
+			mode:      config.HAModeCAS,
+			corrupted: true,
+			wantErr:   true,
+		},
+		{
+			name:       "rebuild",
+			policy:     config.CorruptionPolicyRebuild,
+			mode:       config.HAModeCAS,
+			corrupted:  true,
+			wantStored: map[int32]string{},
+		},
+		{
+			name:        "rebuild deferred to the leader",
+			policy:      config.CorruptionPolicyRebuild,
+			mode:        config.HAModeLeader,
+			corrupted:   true,
+			wantPending: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cm := stateConfigMap(t, testStateName, map[string]*RangeState{"team": valid})
+			if tt.corrupted {
+				cm.Data["team"] = "garbage"
+			}
+			c := newFakeClient(t, nil, cm)
+			manager := newTestManager(t, c, testConfig(tt.policy, tt.mode))
+
+			err := manager.Initialize(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Initialize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var corruption *StateCorruptionError
+				if !errors.As(err, &corruption) {
+					t.Errorf("Initialize() error = %v, want *StateCorruptionError", err)
+				}
+			} else {
+				manager.MarkStateLoaded()
+				if got := manager.GetPortRange("team").RebuildPending(); got != tt.wantPending {
+					t.Errorf("RebuildPending() = %v, want %v", got, tt.wantPending)
+				}
+				if err := manager.StateReadyCheck(nil); (err != nil) != tt.wantPending {
+					t.Errorf("StateReadyCheck() = %v, want ready %v", err, !tt.wantPending)
+				}
+			}
+
+			stored, err := manager.storage.LoadState(ctx, "team", 30000, 30009)
+			if tt.wantStored == nil {
+				var corruption *StateCorruptionError
+				if !errors.As(err, &corruption) {
+					t.Errorf("LoadState() error = %v, corrupted data was overwritten", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadState: %v", err)
+			}
+			if !reflect.DeepEqual(stored.Owners, tt.wantStored) {
+				t.Errorf("stored owners = %v, want %v", stored.Owners, tt.wantStored)
+			}
+		})
+	}
+}
+
+// TestRebuildCorrupted Leader 在一次写入中按集群中的 Services 重建损坏的范围，重建后副本恢复就绪
+func TestRebuildCorrupted(t *testing.T) {
+	ctx := context.Background()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: testStateName, Namespace: testNamespace},
+		Data:       map[string]string{"team": "garbage"},
+	}
+	// other 命名空间的 Service 使用 team 范围内的端口，按端口数值登记
+	c := newFakeClient(t, nil, cm, testService("team", "web", 30002), testService("other", "legacy", 30005, 30015))
+	cfg := testConfig(config.CorruptionPolicyRebuild, config.HAModeLeader)
+	leader, follower := newTestManager(t, c, cfg), newTestManager(t, c, cfg)
+	for _, manager := range []*Manager{leader, follower} {
+		if err := manager.Initialize(ctx); err != nil {
+			t.Fatalf("Initialize: %v", err)
+		}
+		manager.MarkStateLoaded()
+	}
+
+	if err := leader.RebuildCorrupted(ctx); err != nil {
+		t.Fatalf("RebuildCorrupted: %v", err)
+	}
+	if err := leader.StateReadyCheck(nil); err != nil {
+		t.Errorf("leader StateReadyCheck() = %v after rebuild", err)
+	}
+	want := map[int32]string{30002: "team/web", 30005: "other/legacy"}
+	stored, err := leader.storage.LoadState(ctx, "team", 30000, 30009)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if !reflect.DeepEqual(stored.Owners, want) {
+		t.Errorf("stored owners = %v, want %v", stored.Owners, want)
+	}
+
+	if err := follower.StateReadyCheck(nil); err == nil {
+		t.Error("follower is ready before it loaded the rebuilt state")
+	}
+	if err := follower.Refresh(ctx); err != nil {
+		t.Fatalf("follower Refresh: %v", err)
+	}
+	if err := follower.StateReadyCheck(nil); err != nil {
+		t.Errorf("follower StateReadyCheck() = %v after refresh", err)
+	}
+	if got := follower.GetPortRange("team").OwnerOf(30005); got != "other/legacy" {
+		t.Errorf("follower OwnerOf(30005) = %q, want other/legacy", got)
+	}
+}
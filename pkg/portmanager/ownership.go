@@ -0,0 +1,120 @@
+package portmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/utils"
+)
+
+// ownersKeySuffix 所属关系记录在ConfigMap中的键后缀，与位图保存在同一对象中，随位图一起以 CAS 方式写入
+const ownersKeySuffix = ".owners"
+
+// RangeState 端口范围在存储中的状态：位图以及各端口的所属 Service
+// 旧版本写入的数据没有所属记录，对应端口的 Owner 为空
+type RangeState struct {
+	BitSet *utils.BitSet
+	Owners map[int32]string
+}
+
+// StateMutation 基于存储中最新状态执行的修改，返回状态是否发生变化
+type StateMutation func(state *RangeState) (bool, error)
+
+// newRangeState 创建空的范围状态
+func newRangeState(start, end int32) *RangeState {
+	return &RangeState{
+		BitSet: utils.NewBitSet(start, end),
+		Owners: make(map[int32]string),
+	}
+}
+
+// Owner 返回端口的所属 Service（namespace/name），未记录时为空
+func (s *RangeState) Owner(port int32) string {
+	return s.Owners[port]
+}
+
+// PortsOwnedBy 返回指定 Service 拥有的端口，按端口号排序
+func (s *RangeState) PortsOwnedBy(owner string) []int32 {
+	var ports []int32
+	for port, o := range s.Owners {
+		if o == owner {
+			ports = append(ports, port)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// Set 标记端口为已使用并记录所属 Service，owner 为空时只标记端口
+func (s *RangeState) Set(port int32, owner string) error {
+	if err := s.BitSet.Set(port); err != nil {
+		return err
+	}
+	if owner != "" {
+		s.Owners[port] = owner
+	}
+	return nil
+}
+
+// Clear 释放端口并删除所属记录
+func (s *RangeState) Clear(port int32) error {
+	if err := s.BitSet.Clear(port); err != nil {
+		return err
+	}
+	delete(s.Owners, port)
+	return nil
+}
+
+// prune 删除位图中未使用端口的所属记录，保证二者一致
+func (s *RangeState) prune() {
+	for port := range s.Owners {
+		if !s.BitSet.Test(port) {
+			delete(s.Owners, port)
+		}
+	}
+}
+
+// OwnerKey 返回 Service 在所属记录中的标识
+func OwnerKey(service *corev1.Service) string {
+	return fmt.Sprintf("%s/%s", serviceNamespace(service), service.Name)
+}
+
+// ownersKey 端口范围所属记录在ConfigMap中的键
+func ownersKey(rangeName string) string {
+	return rangeName + ownersKeySuffix
+}
+
+// encodeOwners 序列化所属记录，键为端口号字符串
+func encodeOwners(owners map[int32]string) (string, error) {
+	data := make(map[string]string, len(owners))
+	for port, owner := range owners {
+		data[strconv.Itoa(int(port))] = owner
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// decodeOwners 解析所属记录
+func decodeOwners(data string) (map[int32]string, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+
+	owners := make(map[int32]string, len(raw))
+	for key, owner := range raw {
+		port, err := strconv.ParseInt(key, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("无效的端口号 %q", key)
+		}
+		owners[int32(port)] = owner
+	}
+	return owners, nil
+}
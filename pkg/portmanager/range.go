@@ -2,11 +2,15 @@ package portmanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
 	"github.com/tiggoins/nodeport-allocator/pkg/utils"
 )
 
@@ -15,9 +19,35 @@ type PortRange struct {
 	name    string
 	config  config.PortRange
 	bitSet  *utils.BitSet
+	owners  map[int32]string
 	storage *Storage
 	logger  logr.Logger
 	mutex   sync.RWMutex
+	// lockedAt 写锁的获取时间（UnixNano），未持有时为 0，供存活检查发现卡住的锁
+	lockedAt atomic.Int64
+	// rebuildPending 存储中的状态已损坏、内存缓存为空，等待 Leader 重建；从存储加载到有效状态后清除
+	rebuildPending bool
+}
+
+// lock 获取写锁并记录获取时间
+func (pr *PortRange) lock() {
+	pr.mutex.Lock()
+	pr.lockedAt.Store(time.Now().UnixNano())
+}
+
+// unlock 释放写锁
+func (pr *PortRange) unlock() {
+	pr.lockedAt.Store(0)
+	pr.mutex.Unlock()
+}
+
+// lockHeldFor 返回写锁已被持有的时长，未持有时为 0
+func (pr *PortRange) lockHeldFor() time.Duration {
+	lockedAt := pr.lockedAt.Load()
+	if lockedAt == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, lockedAt))
 }
 
 // NewPortRange 创建新的端口范围管理器
@@ -32,14 +62,14 @@ func NewPortRange(name string, config config.PortRange, storage *Storage, logger
 
 // Initialize 初始化端口范围
 func (pr *PortRange) Initialize(ctx context.Context) error {
-	pr.mutex.Lock()
-	defer pr.mutex.Unlock()
+	pr.lock()
+	defer pr.unlock()
 
-	var err error
-	pr.bitSet, err = pr.storage.LoadBitSet(ctx, pr.name, pr.config.Start, pr.config.End)
+	state, err := pr.storage.LoadState(ctx, pr.name, pr.config.Start, pr.config.End)
 	if err != nil {
-		return fmt.Errorf("初始化端口范围 %s 失败: %v", pr.name, err)
+		return fmt.Errorf("初始化端口范围 %s 失败: %w", pr.name, err)
 	}
+	pr.setState(state)
 
 	pr.logger.Info("端口范围初始化完成",
 		"start", pr.config.Start,
@@ -50,126 +80,360 @@ func (pr *PortRange) Initialize(ctx context.Context) error {
 	return nil
 }
 
-// AllocatePort 分配端口
-func (pr *PortRange) AllocatePort(ctx context.Context, requestedPort int32) (int32, error) {
-	pr.mutex.Lock()
-	defer pr.mutex.Unlock()
+// AllocatePort 为 owner（namespace/name）分配端口，返回端口以及是否为本次新分配
+// 分配基于存储中的最新位图以 CAS 方式完成，避免多副本之间重复分配同一端口；
+// 指定的端口已属于 owner 时直接返回，不视为冲突
+func (pr *PortRange) AllocatePort(ctx context.Context, requestedPort int32, owner string) (int32, bool, error) {
+	pr.lock()
+	defer pr.unlock()
 
 	if pr.bitSet == nil {
-		return 0, fmt.Errorf("端口范围未初始化")
+		return 0, false, fmt.Errorf("端口范围未初始化")
 	}
 
-	var port int32
-	var err error
+	// 业务逻辑层检查：确保用户请求的端口在允许的范围内
+	if requestedPort != 0 && !pr.Contains(requestedPort) {
+		return 0, false, newAllocationError(ReasonOutsideRange, requestedPort,
+			i18n.T(ctx, i18n.PortOutsideRange, requestedPort, pr.config.Start, pr.config.End))
+	}
 
-	if requestedPort != 0 {
-		// 分配指定端口
-		// 业务逻辑层检查：确保用户请求的端口在允许的范围内
-		if requestedPort < pr.config.Start || requestedPort > pr.config.End {
-			return 0, fmt.Errorf("端口 %d 超出允许的范围 [%d, %d]", requestedPort, pr.config.Start, pr.config.End)
+	var port int32
+	var allocated bool
+	stored, err := pr.storage.UpdateState(ctx, pr.name, pr.config.Start, pr.config.End, func(state *RangeState) (bool, error) {
+		allocated = false
+		if requestedPort != 0 {
+			// 分配指定端口
+			port = requestedPort
+			if state.BitSet.Test(requestedPort) {
+				if owner != "" && state.Owner(requestedPort) == owner {
+					return false, nil
+				}
+				return false, newAllocationError(ReasonPortInUse, requestedPort, i18n.T(ctx, i18n.PortInUse, requestedPort))
+			}
+		} else {
+			// 自动分配端口
+			var found bool
+			port, found = state.BitSet.FindFirstClear()
+			if !found {
+				return false, newAllocationError(ReasonRangeExhausted, 0, i18n.T(ctx, i18n.RangeExhausted, pr.name))
+			}
 		}
 
-		if pr.bitSet.Test(requestedPort) {
-			return 0, fmt.Errorf("端口 %d 已被使用", requestedPort)
+		// 标记端口为已使用
+		if err := state.Set(port, owner); err != nil {
+			return false, fmt.Errorf("标记端口失败: %v", err)
 		}
-
-		port = requestedPort
-	} else {
-		// 自动分配端口
-		var found bool
-		port, found = pr.bitSet.FindFirstClear()
-		if !found {
-			return 0, fmt.Errorf("端口范围 %s 已满", pr.name)
+		allocated = true
+		return true, nil
+	})
+	if err != nil {
+		// 分配被拒绝（端口已被使用、范围已满等）时直接返回原因
+		var allocationErr *AllocationError
+		if errors.As(err, &allocationErr) {
+			return 0, false, err
 		}
+		return 0, false, fmt.Errorf("保存端口状态失败: %w", err)
 	}
 
-	// 标记端口为已使用
-	if err = pr.bitSet.Set(port); err != nil {
-		return 0, fmt.Errorf("标记端口失败: %v", err)
-	}
+	// 以存储中的状态刷新内存缓存
+	pr.setState(stored)
 
-	// 保存到存储
-	if err = pr.storage.SaveBitSet(ctx, pr.name, pr.bitSet); err != nil {
-		// 回滚
-		pr.bitSet.Clear(port)
-		return 0, fmt.Errorf("保存端口状态失败: %v", err)
+	if !allocated {
+		pr.logger.Info("端口已属于该Service", "port", port, "owner", owner)
+		return port, false, nil
 	}
 
-	pr.logger.Info("端口分配成功", "port", port)
-	return port, nil
+	pr.logger.Info("端口分配成功", "port", port, "owner", owner)
+	return port, true, nil
 }
 
 // ReleasePort 释放端口
 func (pr *PortRange) ReleasePort(ctx context.Context, port int32) error {
-	pr.mutex.Lock()
-	defer pr.mutex.Unlock()
+	pr.lock()
+	defer pr.unlock()
 
 	if pr.bitSet == nil {
 		return fmt.Errorf("端口范围未初始化")
 	}
 
 	// 业务逻辑层检查：确保释放的端口在允许的范围内
-	if port < pr.config.Start || port > pr.config.End {
+	if !pr.Contains(port) {
 		return fmt.Errorf("端口 %d 超出允许的范围 [%d, %d]", port, pr.config.Start, pr.config.End)
 	}
 
-	if !pr.bitSet.Test(port) {
+	released := false
+	stored, err := pr.storage.UpdateState(ctx, pr.name, pr.config.Start, pr.config.End, func(state *RangeState) (bool, error) {
+		released = false
+		if !state.BitSet.Test(port) {
+			return false, nil
+		}
+
+		// 清除端口标记
+		if err := state.Clear(port); err != nil {
+			return false, fmt.Errorf("清除端口标记失败: %v", err)
+		}
+		released = true
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("保存端口状态失败: %v", err)
+	}
+
+	pr.setState(stored)
+
+	if !released {
 		pr.logger.Info("端口未被使用，跳过释放", "port", port)
 		return nil
 	}
 
-	// 清除端口标记
-	if err := pr.bitSet.Clear(port); err != nil {
-		return fmt.Errorf("清除端口标记失败: %v", err)
+	pr.logger.Info("端口释放成功", "port", port)
+	return nil
+}
+
+// ReleaseOwned 在一次存储写入中释放 owner 拥有的端口，keep 中的端口保留
+// unowned 中没有所属记录（旧版本写入）的端口同样释放，属于其他 Service 的端口不受影响
+func (pr *PortRange) ReleaseOwned(ctx context.Context, owner string, unowned []int32, keep map[int32]bool) ([]int32, error) {
+	pr.lock()
+	defer pr.unlock()
+
+	if pr.bitSet == nil {
+		return nil, fmt.Errorf("端口范围未初始化")
 	}
 
-	// 保存到存储
-	if err := pr.storage.SaveBitSet(ctx, pr.name, pr.bitSet); err != nil {
-		// 回滚
-		pr.bitSet.Set(port)
-		return fmt.Errorf("保存端口状态失败: %v", err)
+	var released []int32
+	stored, err := pr.storage.UpdateState(ctx, pr.name, pr.config.Start, pr.config.End, func(state *RangeState) (bool, error) {
+		released = nil
+		candidates := state.PortsOwnedBy(owner)
+		for _, port := range unowned {
+			if pr.Contains(port) && state.BitSet.Test(port) && state.Owner(port) == "" {
+				candidates = append(candidates, port)
+			}
+		}
+
+		for _, port := range candidates {
+			if keep[port] {
+				continue
+			}
+			if err := state.Clear(port); err != nil {
+				return false, fmt.Errorf("清除端口标记失败: %v", err)
+			}
+			released = append(released, port)
+		}
+		return len(released) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存端口状态失败: %w", err)
 	}
 
-	pr.logger.Info("端口释放成功", "port", port)
-	return nil
+	pr.setState(stored)
+
+	if len(released) > 0 {
+		pr.logger.Info("端口释放成功", "ports", released, "owner", owner)
+	}
+	return released, nil
 }
 
-// MarkPortAsUsed 标记端口为已使用（用于初始化现有服务）
-func (pr *PortRange) MarkPortAsUsed(ctx context.Context, port int32) error {
-	pr.mutex.Lock()
-	defer pr.mutex.Unlock()
+// MarkPortAsUsed 标记端口为已使用并记录所属 Service（用于初始化现有服务）
+// 集群中的 Service 是端口归属的唯一事实来源，已有的所属记录与之不一致时以集群为准
+func (pr *PortRange) MarkPortAsUsed(ctx context.Context, port int32, owner string) error {
+	pr.lock()
+	defer pr.unlock()
 
 	if pr.bitSet == nil {
 		return fmt.Errorf("端口范围未初始化")
 	}
 
 	// 业务逻辑层检查：确保标记的端口在允许的范围内
-	if port < pr.config.Start || port > pr.config.End {
+	if !pr.Contains(port) {
 		return fmt.Errorf("端口 %d 超出允许的范围 [%d, %d]", port, pr.config.Start, pr.config.End)
 	}
 
-	// 如果端口已经被标记为使用，直接返回成功
-	if pr.bitSet.Test(port) {
+	marked := false
+	stored, err := pr.storage.UpdateState(ctx, pr.name, pr.config.Start, pr.config.End, func(state *RangeState) (bool, error) {
+		marked = false
+		// 如果端口已经被标记为使用且所属记录一致，无需写入
+		if state.BitSet.Test(port) && (owner == "" || state.Owner(port) == owner) {
+			return false, nil
+		}
+
+		// 标记端口为已使用
+		if err := state.Set(port, owner); err != nil {
+			return false, fmt.Errorf("标记端口失败: %v", err)
+		}
+		marked = true
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("保存端口状态失败: %w", err)
+	}
+
+	pr.setState(stored)
+
+	if !marked {
 		pr.logger.Info("端口已被标记为使用", "port", port)
 		return nil
 	}
 
-	// 标记端口为已使用
-	if err := pr.bitSet.Set(port); err != nil {
-		return fmt.Errorf("标记端口失败: %v", err)
+	pr.logger.Info("端口标记为已使用", "port", port, "owner", owner)
+	return nil
+}
+
+// RegisterPort 将端口登记为 owner 所有，返回登记前的所属 Service
+// 与 MarkPortAsUsed 不同，端口已登记为其他 Service 所有时不覆盖所属记录，由调用方处理冲突
+func (pr *PortRange) RegisterPort(ctx context.Context, port int32, owner string) (string, error) {
+	pr.lock()
+	defer pr.unlock()
+
+	if pr.bitSet == nil {
+		return "", fmt.Errorf("端口范围未初始化")
+	}
+	if !pr.Contains(port) {
+		return "", fmt.Errorf("端口 %d 超出允许的范围 [%d, %d]", port, pr.config.Start, pr.config.End)
 	}
 
-	// 保存到存储
-	if err := pr.storage.SaveBitSet(ctx, pr.name, pr.bitSet); err != nil {
-		// 回滚
-		pr.bitSet.Clear(port)
-		return fmt.Errorf("保存端口状态失败: %v", err)
+	var current string
+	stored, err := pr.storage.UpdateState(ctx, pr.name, pr.config.Start, pr.config.End, func(state *RangeState) (bool, error) {
+		current = state.Owner(port)
+		if state.BitSet.Test(port) && current != "" {
+			return false, nil
+		}
+		// 未使用或没有所属记录（旧版本写入）的端口直接登记
+		if err := state.Set(port, owner); err != nil {
+			return false, fmt.Errorf("标记端口失败: %v", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("保存端口状态失败: %w", err)
+	}
+
+	pr.setState(stored)
+	if current == "" {
+		pr.logger.Info("端口登记成功", "port", port, "owner", owner)
+	}
+	return current, nil
+}
+
+// Reset 清空存储中的位图，用于状态损坏后重建；mutate 不为 nil 时在同一次写入中从空状态开始执行
+func (pr *PortRange) Reset(ctx context.Context, mutate StateMutation) error {
+	pr.lock()
+	defer pr.unlock()
+
+	state, err := pr.storage.ResetState(ctx, pr.name, pr.config.Start, pr.config.End, mutate)
+	if err != nil {
+		return err
+	}
+
+	pr.setState(state)
+	pr.logger.Info("端口范围状态已重置")
+	return nil
+}
+
+// PreviewPort 基于内存缓存为 owner 预选端口，不写入存储（用于 dryRun 请求），返回端口以及是否为新占用
+// reserved 为同一请求中已预选的端口
+func (pr *PortRange) PreviewPort(ctx context.Context, requestedPort int32, owner string, reserved map[int32]bool) (int32, bool, error) {
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+
+	if pr.bitSet == nil {
+		return 0, false, fmt.Errorf("端口范围未初始化")
+	}
+
+	if requestedPort != 0 {
+		if !pr.Contains(requestedPort) {
+			return 0, false, newAllocationError(ReasonOutsideRange, requestedPort,
+				i18n.T(ctx, i18n.PortOutsideRange, requestedPort, pr.config.Start, pr.config.End))
+		}
+		if pr.bitSet.Test(requestedPort) || reserved[requestedPort] {
+			if owner != "" && pr.owners[requestedPort] == owner {
+				return requestedPort, false, nil
+			}
+			return 0, false, newAllocationError(ReasonPortInUse, requestedPort, i18n.T(ctx, i18n.PortInUse, requestedPort))
+		}
+		return requestedPort, true, nil
+	}
+
+	for port := pr.config.Start; port <= pr.config.End; port++ {
+		if !pr.bitSet.Test(port) && !reserved[port] {
+			return port, true, nil
+		}
+	}
+	return 0, false, newAllocationError(ReasonRangeExhausted, 0, i18n.T(ctx, i18n.RangeExhausted, pr.name))
+}
+
+// Refresh 从存储重新加载位图，使内存缓存与其他副本写入的状态保持一致
+func (pr *PortRange) Refresh(ctx context.Context) error {
+	state, err := pr.storage.LoadState(ctx, pr.name, pr.config.Start, pr.config.End)
+	if err != nil {
+		return fmt.Errorf("刷新端口范围 %s 失败: %w", pr.name, err)
 	}
 
-	pr.logger.Info("端口标记为已使用", "port", port)
+	pr.lock()
+	pr.setState(state)
+	pr.unlock()
 	return nil
 }
 
+// resetCache 将内存缓存置为空状态并标记等待重建，不写入存储（存储中的状态损坏、等待 Leader 重建时使用）
+func (pr *PortRange) resetCache() {
+	pr.lock()
+	defer pr.unlock()
+	pr.setState(newRangeState(pr.config.Start, pr.config.End))
+	pr.rebuildPending = true
+}
+
+// RebuildPending 判断该范围是否仍在等待 Leader 重建，此时内存缓存不反映已使用的端口
+func (pr *PortRange) RebuildPending() bool {
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+	return pr.rebuildPending
+}
+
+// setState 以存储中的状态刷新内存缓存，调用方需持有写锁
+func (pr *PortRange) setState(state *RangeState) {
+	pr.bitSet = state.BitSet
+	pr.owners = state.Owners
+	pr.rebuildPending = false
+}
+
+// OwnedPorts 返回内存缓存中 owner 拥有的端口
+// 缓存可能落后于存储，只适合用于判断是否需要执行释放等写操作
+func (pr *PortRange) OwnedPorts(owner string) []int32 {
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+
+	state := RangeState{BitSet: pr.bitSet, Owners: pr.owners}
+	return state.PortsOwnedBy(owner)
+}
+
+// OwnerOf 返回内存缓存中端口的所属 Service，未记录时返回空字符串
+func (pr *PortRange) OwnerOf(port int32) string {
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+	return pr.owners[port]
+}
+
+// Owners 返回内存缓存中拥有端口的所有 owner
+func (pr *PortRange) Owners() []string {
+	pr.mutex.RLock()
+	defer pr.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	var owners []string
+	for _, owner := range pr.owners {
+		if !seen[owner] {
+			seen[owner] = true
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// Contains 判断端口是否在该范围内
+func (pr *PortRange) Contains(port int32) bool {
+	return port >= pr.config.Start && port <= pr.config.End
+}
+
 // IsPortUsed 检查端口是否被使用
 func (pr *PortRange) IsPortUsed(port int32) bool {
 	pr.mutex.RLock()
@@ -0,0 +1,173 @@
+package portmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/utils"
+)
+
+// shardIndexKey 主ConfigMap中保存分片索引的键
+const shardIndexKey = "shard-index.json"
+
+// shardIndex 分片索引，记录每个端口范围所在的分片ConfigMap
+// 范围一旦分配到分片就不再移动，新增范围不会影响已有范围的位置
+type shardIndex struct {
+	Version int               `json:"version"`
+	Shards  map[string]string `json:"shards"`
+}
+
+// sharded 是否开启分片存储
+func (s *Storage) sharded() bool {
+	return s.config.RangesPerShard > 0
+}
+
+// objectForRange 返回保存指定范围状态的ConfigMap名称
+// 未开启分片时始终为主ConfigMap；开启分片时查询索引，范围尚未分配分片时：
+// assign 为 false 返回空字符串，assign 为 true 则为其分配分片并以 CAS 方式写入索引
+func (s *Storage) objectForRange(ctx context.Context, rangeName string, assign bool) (string, error) {
+	if !s.sharded() {
+		return s.config.ConfigMapName, nil
+	}
+
+	var objectName string
+	err := s.retry(ctx, "更新分片索引", func() error {
+		cm, err := s.getConfigMap(ctx, s.config.ConfigMapName)
+		if err != nil {
+			if !utils.IsObjectNotFound(err) {
+				return fmt.Errorf("获取分片索引失败: %v", err)
+			}
+			cm = nil
+		}
+
+		index, err := decodeShardIndex(cm)
+		if err != nil {
+			return err
+		}
+
+		if shard, exists := index.Shards[rangeName]; exists || !assign {
+			objectName = shard
+			return nil
+		}
+
+		objectName = index.assign(rangeName, s.config.ConfigMapName, s.config.RangesPerShard)
+		data, err := json.Marshal(index)
+		if err != nil {
+			return fmt.Errorf("序列化分片索引失败: %v", err)
+		}
+
+		if cm == nil {
+			return s.createConfigMap(ctx, s.config.ConfigMapName, map[string]string{shardIndexKey: string(data)})
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[shardIndexKey] = string(data)
+		if err := s.client.Update(ctx, cm); err != nil {
+			if apierrors.IsConflict(err) {
+				return err
+			}
+			return fmt.Errorf("更新分片索引失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if assign {
+		s.logger.V(1).Info("端口范围所在分片", "range", rangeName, "shard", objectName)
+	}
+	return objectName, nil
+}
+
+// removeLegacyData 范围数据迁移到分片后，尽力删除主ConfigMap中未分片时的旧数据
+func (s *Storage) removeLegacyData(ctx context.Context, rangeName string) {
+	err := s.retry(ctx, "清理旧存储数据", func() error {
+		cm, err := s.getConfigMap(ctx, s.config.ConfigMapName)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if _, exists := cm.Data[rangeName]; !exists {
+			return nil
+		}
+
+		delete(cm.Data, rangeName)
+		delete(cm.Data, ownersKey(rangeName))
+		return s.client.Update(ctx, cm)
+	})
+	if err != nil {
+		s.logger.Error(err, "清理旧存储数据失败", "range", rangeName)
+		return
+	}
+
+	s.logger.Info("端口范围数据已迁移到分片", "range", rangeName)
+}
+
+// decodeShardIndex 解析分片索引，ConfigMap 或索引不存在时返回空索引
+func decodeShardIndex(cm *corev1.ConfigMap) (*shardIndex, error) {
+	index := &shardIndex{Version: 1, Shards: make(map[string]string)}
+	if cm == nil {
+		return index, nil
+	}
+
+	data, exists := cm.Data[shardIndexKey]
+	if !exists {
+		return index, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), index); err != nil {
+		return nil, fmt.Errorf("解析分片索引失败: %v", err)
+	}
+	if index.Shards == nil {
+		index.Shards = make(map[string]string)
+	}
+	return index, nil
+}
+
+// assign 为范围分配分片：优先放入未满的已有分片，否则新建分片
+func (idx *shardIndex) assign(rangeName, baseName string, rangesPerShard int) string {
+	counts := make(map[string]int)
+	for _, shard := range idx.Shards {
+		counts[shard]++
+	}
+
+	shards := make([]string, 0, len(counts))
+	for shard := range counts {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+
+	for _, shard := range shards {
+		if counts[shard] < rangesPerShard {
+			idx.Shards[rangeName] = shard
+			return shard
+		}
+	}
+
+	for i := len(shards); ; i++ {
+		shard := shardName(baseName, i)
+		if _, used := counts[shard]; !used {
+			idx.Shards[rangeName] = shard
+			return shard
+		}
+	}
+}
+
+// shardName 分片ConfigMap名称
+func shardName(baseName string, i int) string {
+	return fmt.Sprintf("%s-shard-%d", baseName, i)
+}
+
+// isShardName 判断名称是否为分片ConfigMap
+func isShardName(baseName, name string) bool {
+	return strings.HasPrefix(name, baseName+"-shard-")
+}
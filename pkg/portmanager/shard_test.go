@@ -0,0 +1,182 @@
+package portmanager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestObjectForRangeAssignsShards(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestStorage(t, newFakeClient(t, nil), 2)
+
+	// 步骤按顺序执行，共享同一分片索引
+	steps := []struct {
+		rangeName string
+		assign    bool
+		want      string
+	}{
+		{rangeName: "a", assign: false, want: ""},
+		{rangeName: "a", assign: true, want: "state-shard-0"},
+		{rangeName: "b", assign: true, want: "state-shard-0"},
+		{rangeName: "c", assign: true, want: "state-shard-1"},
+		{rangeName: "a", assign: true, want: "state-shard-0"},
+		{rangeName: "c", assign: false, want: "state-shard-1"},
+		{rangeName: "d", assign: false, want: ""},
+	}
+	for _, step := range steps {
+		got, err := storage.objectForRange(ctx, step.rangeName, step.assign)
+		if err != nil {
+			t.Fatalf("objectForRange(%q, %v): %v", step.rangeName, step.assign, err)
+		}
+		if got != step.want {
+			t.Errorf("objectForRange(%q, %v) = %q, want %q", step.rangeName, step.assign, got, step.want)
+		}
+	}
+
+	// 分片已满后新增的范围放入新分片，已有范围的位置不变
+	if got, err := storage.objectForRange(ctx, "d", true); err != nil || got != "state-shard-1" {
+		t.Errorf("objectForRange(d) = %q, %v, want state-shard-1", got, err)
+	}
+	if got, err := storage.objectForRange(ctx, "e", true); err != nil || got != "state-shard-2" {
+		t.Errorf("objectForRange(e) = %q, %v, want state-shard-2", got, err)
+	}
+}
+
+func TestObjectForRangeUnsharded(t *testing.T) {
+	storage := newTestStorage(t, newFakeClient(t, nil), 0)
+	for _, assign := range []bool{false, true} {
+		got, err := storage.objectForRange(context.Background(), "a", assign)
+		if err != nil || got != testStateName {
+			t.Errorf("objectForRange(a, %v) = %q, %v, want %q", assign, got, err, testStateName)
+		}
+	}
+}
+
+func TestStateFromLegacyFallback(t *testing.T) {
+	legacy := stateConfigMap(t, testStateName, map[string]*RangeState{
+		"team": testState(t, 30000, 30009, map[int32]string{30003: "team/legacy"}),
+	})
+	shard := stateConfigMap(t, "state-shard-0", map[string]*RangeState{
+		"team": testState(t, 30000, 30009, map[int32]string{30004: "team/shard"}),
+	})
+
+	tests := []struct {
+		name           string
+		rangesPerShard int
+		objs           []client.Object
+		cm             *corev1.ConfigMap
+		rangeName      string
+		wantOwners     map[int32]string
+		wantLegacy     bool
+	}{
+		{
+			name:           "shard has data",
+			rangesPerShard: 2,
+			objs:           []client.Object{legacy.DeepCopy()},
+			cm:             shard.DeepCopy(),
+			rangeName:      "team",
+			wantOwners:     map[int32]string{30004: "team/shard"},
+		},
+		{
+			name:           "shard missing, legacy data",
+			rangesPerShard: 2,
+			objs:           []client.Object{legacy.DeepCopy()},
+			rangeName:      "team",
+			wantOwners:     map[int32]string{30003: "team/legacy"},
+			wantLegacy:     true,
+		},
+		{
+			name:           "shard without the range, legacy data",
+			rangesPerShard: 2,
+			objs:           []client.Object{legacy.DeepCopy()},
+			cm:             stateConfigMap(t, "state-shard-0", nil),
+			rangeName:      "team",
+			wantOwners:     map[int32]string{30003: "team/legacy"},
+			wantLegacy:     true,
+		},
+		{
+			name:           "no legacy data",
+			rangesPerShard: 2,
+			objs:           []client.Object{legacy.DeepCopy()},
+			rangeName:      "other",
+			wantOwners:     map[int32]string{},
+		},
+		{
+			name:           "no legacy object",
+			rangesPerShard: 2,
+			rangeName:      "team",
+			wantOwners:     map[int32]string{},
+		},
+		{
+			name:       "unsharded, object missing",
+			rangeName:  "team",
+			wantOwners: map[int32]string{},
+		},
+		{
+			name:       "unsharded",
+			cm:         legacy.DeepCopy(),
+			rangeName:  "team",
+			wantOwners: map[int32]string{30003: "team/legacy"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestStorage(t, newFakeClient(t, nil, tt.objs...), tt.rangesPerShard)
+			state, fromLegacy, err := storage.stateFrom(context.Background(), tt.cm, tt.rangeName, 30000, 30009)
+			if err != nil {
+				t.Fatalf("stateFrom: %v", err)
+			}
+			if fromLegacy != tt.wantLegacy {
+				t.Errorf("fromLegacy = %v, want %v", fromLegacy, tt.wantLegacy)
+			}
+			if !reflect.DeepEqual(state.Owners, tt.wantOwners) {
+				t.Errorf("owners = %v, want %v", state.Owners, tt.wantOwners)
+			}
+		})
+	}
+}
+
+// TestUpdateStateMigratesLegacyData 开启分片后首次写入把旧数据写入分片并删除主ConfigMap中的旧键
+func TestUpdateStateMigratesLegacyData(t *testing.T) {
+	ctx := context.Background()
+	legacy := stateConfigMap(t, testStateName, map[string]*RangeState{
+		"team": testState(t, 30000, 30009, map[int32]string{30003: "team/legacy"}),
+	})
+	c := newFakeClient(t, nil, legacy)
+	storage := newTestStorage(t, c, 2)
+
+	_, err := storage.UpdateState(ctx, "team", 30000, 30009, func(state *RangeState) (bool, error) {
+		return true, state.Set(30004, "team/web")
+	})
+	if err != nil {
+		t.Fatalf("UpdateState: %v", err)
+	}
+
+	var main, shard corev1.ConfigMap
+	if err := c.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: testStateName}, &main); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"team", ownersKey("team")} {
+		if _, exists := main.Data[key]; exists {
+			t.Errorf("legacy key %q was not removed from the main ConfigMap", key)
+		}
+	}
+	if _, exists := main.Data[shardIndexKey]; !exists {
+		t.Errorf("main ConfigMap has no shard index")
+	}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "state-shard-0"}, &shard); err != nil {
+		t.Fatal(err)
+	}
+	state, err := storage.decodeState(&shard, "team", 30000, 30009)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int32]string{30003: "team/legacy", 30004: "team/web"}
+	if !reflect.DeepEqual(state.Owners, want) {
+		t.Errorf("shard owners = %v, want %v", state.Owners, want)
+	}
+}
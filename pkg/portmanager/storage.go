@@ -2,29 +2,64 @@ package portmanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/i18n"
+	"github.com/tiggoins/nodeport-allocator/pkg/metrics"
 	"github.com/tiggoins/nodeport-allocator/pkg/utils"
 )
 
 // Storage ConfigMap存储实现
+// 未开启分片时所有范围保存在 configMapName 指定的 ConfigMap 中；
+// 开启分片后该 ConfigMap 仅保存分片索引，各范围的状态保存在各自的分片 ConfigMap 中
 type Storage struct {
 	client     client.Client
+	reader     client.Reader // 直连 apiserver 的读取器，保证 CAS 基于最新 resourceVersion
 	config     *config.StorageConfig
+	recorder   record.EventRecorder
 	logger     logr.Logger
 	retryDelay time.Duration
+
+	// reported 已上报损坏的对象，key 为 ConfigMap 与范围，value 为其 resourceVersion，
+	// 同一版本的损坏数据在每次读取时都会解析失败，只上报一次
+	reportedMutex sync.Mutex
+	reported      map[string]string
+}
+
+// StateCorruptionError 存储中的端口状态无法解析或未通过校验
+type StateCorruptionError struct {
+	RangeName string
+	ConfigMap *corev1.ConfigMap
+	Err       error
+}
+
+// Error 实现error接口
+func (e *StateCorruptionError) Error() string {
+	return fmt.Sprintf("端口范围 %s 的状态数据已损坏 (ConfigMap %s/%s): %v",
+		e.RangeName, e.ConfigMap.Namespace, e.ConfigMap.Name, e.Err)
+}
+
+// Unwrap 返回底层的解析错误
+func (e *StateCorruptionError) Unwrap() error {
+	return e.Err
 }
 
-// NewStorage 创建新的存储实例
-func NewStorage(client client.Client, config *config.StorageConfig, logger logr.Logger) (*Storage, error) {
+// NewStorage 创建新的存储实例，recorder 可为 nil（如命令行工具中）
+func NewStorage(client client.Client, reader client.Reader, config *config.StorageConfig, recorder record.EventRecorder, logger logr.Logger) (*Storage, error) {
 	retryDelay, err := time.ParseDuration(config.RetryDelay)
 	if err != nil {
 		return nil, fmt.Errorf("解析重试延迟失败: %v", err)
@@ -32,123 +67,417 @@ func NewStorage(client client.Client, config *config.StorageConfig, logger logr.
 
 	return &Storage{
 		client:     client,
+		reader:     reader,
 		config:     config,
+		recorder:   recorder,
 		logger:     logger,
 		retryDelay: retryDelay,
+		reported:   make(map[string]string),
 	}, nil
 }
 
-// LoadBitSet 从ConfigMap加载位图数据
-func (s *Storage) LoadBitSet(ctx context.Context, rangeName string, start, end int32) (*utils.BitSet, error) {
-	cm, err := s.getConfigMap(ctx)
+// LoadState 从ConfigMap加载位图以及端口所属记录，数据损坏时返回 *StateCorruptionError
+func (s *Storage) LoadState(ctx context.Context, rangeName string, start, end int32) (*RangeState, error) {
+	objectName, err := s.objectForRange(ctx, rangeName, false)
 	if err != nil {
-		if utils.IsObjectNotFound(err) {
-			// 如果ConfigMap不存在，创建新的位图
-			s.logger.Info("ConfigMap不存在，创建新的位图", "range", rangeName)
-			return utils.NewBitSet(start, end), nil
-		}
-		return nil, fmt.Errorf("获取ConfigMap失败: %v", err)
+		return nil, err
 	}
 
-	data, exists := cm.Data[rangeName]
-	if !exists {
-		// 如果范围数据不存在，创建新的位图
-		s.logger.Info("端口范围数据不存在，创建新位图", "range", rangeName)
-		return utils.NewBitSet(start, end), nil
+	state, _, _, err := s.readState(ctx, objectName, rangeName, start, end)
+	if err != nil {
+		return nil, err
 	}
 
-	bitSet := utils.NewBitSet(start, end)
-	if err := bitSet.FromJSON([]byte(data)); err != nil {
-		s.logger.Error(err, "反序列化位图失败，创建新位图", "range", rangeName)
-		return utils.NewBitSet(start, end), nil
+	s.logger.Info("成功加载位图数据", "range", rangeName, "object", objectName, "used", state.BitSet.Count())
+	return state, nil
+}
+
+// UpdateState 以 resourceVersion 比较并交换（CAS）的方式更新位图与端口所属记录
+// 每次尝试都重新读取存储中的最新状态并在其上执行 mutate，发生冲突时重试，
+// 成功后返回写入存储的状态，调用方应以此刷新内存缓存
+// 存储中的数据已损坏时返回 *StateCorruptionError 而不会覆盖，避免把已使用的端口当作空闲
+func (s *Storage) UpdateState(ctx context.Context, rangeName string, start, end int32, mutate StateMutation) (*RangeState, error) {
+	return s.updateState(ctx, rangeName, start, end, mutate, false)
+}
+
+// ResetState 丢弃存储中的数据（无论是否损坏），从空状态开始执行 mutate 后写入，
+// 用于从集群中的 Services 重建状态或从备份恢复；mutate 为 nil 时写入空状态
+func (s *Storage) ResetState(ctx context.Context, rangeName string, start, end int32, mutate StateMutation) (*RangeState, error) {
+	return s.updateState(ctx, rangeName, start, end, func(state *RangeState) (bool, error) {
+		if mutate != nil {
+			if _, err := mutate(state); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}, true)
+}
+
+// RangesMutation 基于存储中最新状态对多个端口范围执行的修改，返回该范围的状态是否发生变化
+type RangesMutation func(rangeName string, state *RangeState) (bool, error)
+
+// UpdateStates 以 CAS 方式批量更新多个端口范围，保存在同一ConfigMap中的范围只写入一次
+// （未开启分片时所有范围只需一次写入），返回写入后各范围的状态
+func (s *Storage) UpdateStates(ctx context.Context, ranges map[string]config.PortRange, mutate RangesMutation) (map[string]*RangeState, error) {
+	objects := make(map[string][]string)
+	for rangeName := range ranges {
+		objectName, err := s.objectForRange(ctx, rangeName, true)
+		if err != nil {
+			return nil, err
+		}
+		objects[objectName] = append(objects[objectName], rangeName)
+	}
+
+	stored := make(map[string]*RangeState, len(ranges))
+	for objectName, rangeNames := range objects {
+		sort.Strings(rangeNames)
+
+		var migrated []string
+		err := s.retry(ctx, fmt.Sprintf("批量更新 %s 中的端口范围", objectName), func() error {
+			cm, err := s.getStateObject(ctx, objectName)
+			if err != nil {
+				return err
+			}
+
+			data := make(map[string]string)
+			migrated = nil
+			for _, rangeName := range rangeNames {
+				portRange := ranges[rangeName]
+				state, fromLegacy, err := s.stateFrom(ctx, cm, rangeName, portRange.Start, portRange.End)
+				if err != nil {
+					return err
+				}
+
+				changed, err := mutate(rangeName, state)
+				if err != nil {
+					return err
+				}
+				stored[rangeName] = state
+				if !changed {
+					continue
+				}
+
+				state.prune()
+				encoded, err := encodeState(rangeName, state)
+				if err != nil {
+					return err
+				}
+				for key, value := range encoded {
+					data[key] = value
+				}
+				if fromLegacy {
+					migrated = append(migrated, rangeName)
+				}
+			}
+
+			if len(data) == 0 {
+				return nil
+			}
+			return s.writeStateObject(ctx, objectName, cm, data)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rangeName := range migrated {
+			s.removeLegacyData(ctx, rangeName)
+		}
+		s.logger.Info("批量更新端口范围成功", "object", objectName, "ranges", rangeNames)
 	}
 
-	s.logger.Info("成功加载位图数据", "range", rangeName, "used", bitSet.Count())
-	return bitSet, nil
+	return stored, nil
 }
 
-// SaveBitSet 保存位图数据到ConfigMap
-func (s *Storage) SaveBitSet(ctx context.Context, rangeName string, bitSet *utils.BitSet) error {
-	return utils.RetryOnConflict(ctx, s.config.RetryAttempts, s.retryDelay, func() error {
-		return s.saveBitSetOnce(ctx, rangeName, bitSet)
+// updateState 以 CAS 方式更新范围状态，reset 为 true 时忽略存储中的数据，从空状态开始修改
+func (s *Storage) updateState(ctx context.Context, rangeName string, start, end int32, mutate StateMutation, reset bool) (*RangeState, error) {
+	objectName, err := s.objectForRange(ctx, rangeName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored *RangeState
+	var migrated bool
+	err = s.retry(ctx, fmt.Sprintf("更新端口范围 %s 的位图", rangeName), func() error {
+		state, fromLegacy, err := s.updateStateOnce(ctx, objectName, rangeName, start, end, mutate, reset)
+		if err != nil {
+			return err
+		}
+		stored, migrated = state, fromLegacy
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if migrated {
+		s.removeLegacyData(ctx, rangeName)
+	}
+	return stored, nil
+}
+
+// IsStateObject 判断对象是否为端口状态存储（主ConfigMap或其分片）
+func (s *Storage) IsStateObject(namespace, name string) bool {
+	if namespace != s.config.ConfigMapNamespace {
+		return false
+	}
+	return name == s.config.ConfigMapName || isShardName(s.config.ConfigMapName, name)
 }
 
-// saveBitSetOnce 单次保存位图数据
-func (s *Storage) saveBitSetOnce(ctx context.Context, rangeName string, bitSet *utils.BitSet) error {
-	data, err := bitSet.ToJSON()
+// updateStateOnce 单次读取-修改-写入范围状态，返回写入的状态以及初始数据是否来自未分片的旧存储
+func (s *Storage) updateStateOnce(ctx context.Context, objectName, rangeName string, start, end int32, mutate StateMutation, reset bool) (*RangeState, bool, error) {
+	state, cm, fromLegacy, err := s.readState(ctx, objectName, rangeName, start, end)
 	if err != nil {
-		return fmt.Errorf("序列化位图失败: %v", err)
+		var corruption *StateCorruptionError
+		if !reset || !errors.As(err, &corruption) {
+			return nil, false, err
+		}
+	}
+	if reset {
+		state = newRangeState(start, end)
 	}
 
-	cm, err := s.getConfigMap(ctx)
+	changed, err := mutate(state)
 	if err != nil {
-		if utils.IsObjectNotFound(err) {
-			// 创建新的ConfigMap
-			s.logger.Info("创建新的ConfigMap", "name", s.config.ConfigMapName)
-			return s.createConfigMap(ctx, rangeName, string(data))
+		return nil, false, err
+	}
+	if !changed {
+		return state, false, nil
+	}
+	state.prune()
+
+	data, err := encodeState(rangeName, state)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.writeStateObject(ctx, objectName, cm, data); err != nil {
+		if apierrors.IsConflict(err) {
+			s.logger.Info("ConfigMap版本冲突，基于最新状态重试", "range", rangeName, "object", objectName)
 		}
-		return fmt.Errorf("获取ConfigMap失败: %v", err)
+		return nil, false, err
+	}
+
+	s.logger.Info("位图数据保存成功", "range", rangeName, "object", objectName, "used", state.BitSet.Count())
+	return state, fromLegacy, nil
+}
+
+// encodeState 将范围状态序列化为ConfigMap中的键值
+func encodeState(rangeName string, state *RangeState) (map[string]string, error) {
+	data, err := state.BitSet.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("序列化位图失败: %v", err)
+	}
+	owners, err := encodeOwners(state.Owners)
+	if err != nil {
+		return nil, fmt.Errorf("序列化端口所属记录失败: %v", err)
+	}
+	return map[string]string{rangeName: string(data), ownersKey(rangeName): owners}, nil
+}
+
+// writeStateObject 将键值写入状态ConfigMap：cm 为 nil 时创建，否则携带读取时的 resourceVersion 更新，
+// 被其他副本抢先写入时返回冲突错误
+func (s *Storage) writeStateObject(ctx context.Context, objectName string, cm *corev1.ConfigMap, data map[string]string) error {
+	if cm == nil {
+		// 创建新的ConfigMap
+		s.logger.Info("创建新的ConfigMap", "name", objectName)
+		return s.createConfigMap(ctx, objectName, data)
 	}
 
-	// 更新现有ConfigMap
 	if cm.Data == nil {
 		cm.Data = make(map[string]string)
 	}
-	cm.Data[rangeName] = string(data)
+	for key, value := range data {
+		cm.Data[key] = value
+	}
+
+	if err := s.client.Update(ctx, cm); err != nil {
+		if apierrors.IsConflict(err) {
+			return err
+		}
+		return fmt.Errorf("更新ConfigMap失败: %w", err)
+	}
+	return nil
+}
 
-	if err := utils.UpdateObject(ctx, s.client, cm, 1, time.Second); err != nil {
-		return fmt.Errorf("更新ConfigMap失败: %v", err)
+// readState 读取指定对象中的范围状态，同时返回读取到的ConfigMap（不存在时为 nil）
+func (s *Storage) readState(ctx context.Context, objectName, rangeName string, start, end int32) (*RangeState, *corev1.ConfigMap, bool, error) {
+	cm, err := s.getStateObject(ctx, objectName)
+	if err != nil {
+		return nil, nil, false, err
 	}
 
-	s.logger.Info("位图数据保存成功", "range", rangeName, "used", bitSet.Count())
+	state, fromLegacy, err := s.stateFrom(ctx, cm, rangeName, start, end)
+	return state, cm, fromLegacy, err
+}
+
+// Ping 检查存储是否可以访问，主ConfigMap尚未创建不视为异常
+func (s *Storage) Ping(ctx context.Context) error {
+	if _, err := s.getConfigMap(ctx, s.config.ConfigMapName); err != nil && !utils.IsObjectNotFound(err) {
+		return fmt.Errorf("%w: 获取ConfigMap %s 失败: %w", ErrStorageUnavailable, s.config.ConfigMapName, err)
+	}
 	return nil
 }
 
+// getStateObject 获取保存状态的ConfigMap，名称为空或对象不存在时返回 nil
+func (s *Storage) getStateObject(ctx context.Context, objectName string) (*corev1.ConfigMap, error) {
+	if objectName == "" {
+		return nil, nil
+	}
+
+	cm, err := s.getConfigMap(ctx, objectName)
+	if err != nil {
+		if !utils.IsObjectNotFound(err) {
+			return nil, fmt.Errorf("获取ConfigMap %s 失败: %w", objectName, err)
+		}
+		return nil, nil
+	}
+	return cm, nil
+}
+
+// stateFrom 从已读取的ConfigMap（可为 nil）中解析范围状态，并返回数据是否来自未分片的旧存储
+// 分片模式下分片中尚无该范围数据时，回退读取未分片时保存在主ConfigMap中的旧数据
+func (s *Storage) stateFrom(ctx context.Context, cm *corev1.ConfigMap, rangeName string, start, end int32) (*RangeState, bool, error) {
+	if cm != nil {
+		if _, exists := cm.Data[rangeName]; exists || !s.sharded() {
+			state, err := s.decodeState(cm, rangeName, start, end)
+			return state, false, err
+		}
+	} else if !s.sharded() {
+		// 如果ConfigMap不存在，创建新的位图
+		s.logger.Info("ConfigMap不存在，创建新的位图", "range", rangeName)
+		return newRangeState(start, end), false, nil
+	}
+
+	legacy, err := s.getConfigMap(ctx, s.config.ConfigMapName)
+	if err != nil {
+		if !utils.IsObjectNotFound(err) {
+			return nil, false, fmt.Errorf("获取ConfigMap %s 失败: %v", s.config.ConfigMapName, err)
+		}
+		return newRangeState(start, end), false, nil
+	}
+	if _, exists := legacy.Data[rangeName]; !exists {
+		return newRangeState(start, end), false, nil
+	}
+
+	s.logger.Info("从未分片的旧存储读取端口范围数据", "range", rangeName)
+	state, err := s.decodeState(legacy, rangeName, start, end)
+	return state, true, err
+}
+
+// decodeState 从ConfigMap中解析指定范围的位图与所属记录，数据不存在时返回空状态，
+// 数据损坏时上报事件与指标并返回 *StateCorruptionError
+func (s *Storage) decodeState(cm *corev1.ConfigMap, rangeName string, start, end int32) (*RangeState, error) {
+	state := newRangeState(start, end)
+
+	data, exists := cm.Data[rangeName]
+	if !exists {
+		// 如果范围数据不存在，创建新的位图
+		s.logger.Info("端口范围数据不存在，创建新位图", "range", rangeName)
+		return state, nil
+	}
+
+	if err := state.BitSet.FromJSON([]byte(data)); err != nil {
+		corruption := &StateCorruptionError{RangeName: rangeName, ConfigMap: cm, Err: err}
+		s.reportCorruption(corruption)
+		return nil, corruption
+	}
+
+	// 旧版本写入的数据没有所属记录
+	if data, exists := cm.Data[ownersKey(rangeName)]; exists {
+		owners, err := decodeOwners(data)
+		if err != nil {
+			corruption := &StateCorruptionError{RangeName: rangeName, ConfigMap: cm, Err: fmt.Errorf("解析端口所属记录失败: %v", err)}
+			s.reportCorruption(corruption)
+			return nil, corruption
+		}
+		state.Owners = owners
+		state.prune()
+	}
+
+	return state, nil
+}
+
+// reportCorruption 记录状态损坏的日志、Kubernetes Event 与指标，每个损坏的对象版本只上报一次
+func (s *Storage) reportCorruption(err *StateCorruptionError) {
+	key := fmt.Sprintf("%s/%s/%s", err.ConfigMap.Namespace, err.ConfigMap.Name, err.RangeName)
+	s.reportedMutex.Lock()
+	if s.reported[key] == err.ConfigMap.ResourceVersion {
+		s.reportedMutex.Unlock()
+		return
+	}
+	s.reported[key] = err.ConfigMap.ResourceVersion
+	s.reportedMutex.Unlock()
+
+	s.logger.Error(err, "检测到端口状态数据损坏", "range", err.RangeName, "configmap", err.ConfigMap.Name)
+	metrics.StateCorruptionTotal.WithLabelValues(err.RangeName).Inc()
+	if s.recorder != nil {
+		s.recorder.Event(err.ConfigMap, corev1.EventTypeWarning, "StateCorrupted",
+			i18n.Sprintf(i18n.Default(), i18n.EventStateCorrupted, err.RangeName, err.Err))
+	}
+}
+
+// retry 冲突时按存储配置重试，重试次数耗尽时返回可读的错误
+// 冲突重试耗尽以及 apiserver 返回的错误（限流、权限不足等）包装为 ErrStorageUnavailable，
+// mutate 返回的业务错误原样返回
+func (s *Storage) retry(ctx context.Context, action string, fn func() error) error {
+	err := utils.RetryOnConflict(ctx, s.config.RetryAttempts, s.retryDelay, fn)
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("%w: %s时并发冲突，重试 %d 次后仍失败", ErrStorageUnavailable, action, s.config.RetryAttempts)
+	}
+	if isStorageFailure(err) {
+		return fmt.Errorf("%w: %s失败: %w", ErrStorageUnavailable, action, err)
+	}
+	return err
+}
+
+// isStorageFailure 判断错误是否来自访问 apiserver 本身
+func isStorageFailure(err error) bool {
+	var status apierrors.APIStatus
+	return errors.As(err, &status) || errors.Is(err, context.DeadlineExceeded)
+}
+
 // getConfigMap 获取ConfigMap
-func (s *Storage) getConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+func (s *Storage) getConfigMap(ctx context.Context, name string) (*corev1.ConfigMap, error) {
 	cm := &corev1.ConfigMap{}
 	key := types.NamespacedName{
-		Name:      s.config.ConfigMapName,
+		Name:      name,
 		Namespace: s.config.ConfigMapNamespace,
 	}
 
-	err := s.client.Get(ctx, key, cm)
+	err := s.reader.Get(ctx, key, cm)
 	return cm, err
 }
 
 // createConfigMap 创建新的ConfigMap
-func (s *Storage) createConfigMap(ctx context.Context, rangeName, data string) error {
+func (s *Storage) createConfigMap(ctx context.Context, name string, data map[string]string) error {
+	description := "NodePort端口使用状态存储"
+	if name != s.config.ConfigMapName {
+		description = "NodePort端口使用状态存储分片"
+	}
+
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      s.config.ConfigMapName,
+			Name:      name,
 			Namespace: s.config.ConfigMapNamespace,
 			Labels: map[string]string{
 				"app":       "nodeport-allocator",
 				"component": "storage",
 			},
 			Annotations: map[string]string{
-				"nodeport-allocator.example.com/description": "NodePort端口使用状态存储",
+				"nodeport-allocator.example.com/description": description,
 				"nodeport-allocator.example.com/version":     "v1",
 			},
 		},
-		Data: map[string]string{
-			rangeName: data,
-		},
+		Data: data,
 	}
 
-	if err := utils.CreateObject(ctx, s.client, cm, 1, time.Second); err != nil {
-		return fmt.Errorf("创建ConfigMap失败: %v", err)
+	if err := s.client.Create(ctx, cm); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			// 其他副本已抢先创建，按冲突处理以便基于其内容重试
+			return apierrors.NewConflict(corev1.Resource("configmaps"), name, err)
+		}
+		return fmt.Errorf("创建ConfigMap失败: %w", err)
 	}
 
-	s.logger.Info("ConfigMap创建成功", "name", s.config.ConfigMapName, "namespace", s.config.ConfigMapNamespace)
+	s.logger.Info("ConfigMap创建成功", "name", name, "namespace", s.config.ConfigMapNamespace)
 	return nil
 }
-
-
-
-
-
-
-
-
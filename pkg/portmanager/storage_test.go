@@ -0,0 +1,243 @@
+package portmanager
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+const (
+	testNamespace = "kube-system"
+	testStateName = "state"
+)
+
+// testStorageConfig 测试使用的存储配置，rangesPerShard 为 0 时不分片
+func testStorageConfig(rangesPerShard int) config.StorageConfig {
+	return config.StorageConfig{
+		ConfigMapName:      testStateName,
+		ConfigMapNamespace: testNamespace,
+		RangesPerShard:     rangesPerShard,
+		RetryAttempts:      3,
+		RetryDelay:         "1ms",
+		CorruptionPolicy:   config.CorruptionPolicyFail,
+		FailurePolicy:      config.FailurePolicyFail,
+	}
+}
+
+// newFakeClient 创建 fake client，funcs 不为 nil 时拦截对应的调用
+func newFakeClient(t *testing.T, funcs *interceptor.Funcs, objs ...client.Object) client.WithWatch {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...)
+	if funcs != nil {
+		builder = builder.WithInterceptorFuncs(*funcs)
+	}
+	return builder.Build()
+}
+
+// newTestStorage 创建基于 c 的存储
+func newTestStorage(t *testing.T, c client.Client, rangesPerShard int) *Storage {
+	t.Helper()
+	cfg := testStorageConfig(rangesPerShard)
+	storage, err := NewStorage(c, c, &cfg, nil, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// testState 创建范围状态，owners 中的端口标记为已使用，owner 为空表示没有所属记录
+func testState(t *testing.T, start, end int32, owners map[int32]string) *RangeState {
+	t.Helper()
+	state := newRangeState(start, end)
+	for port, owner := range owners {
+		if err := state.Set(port, owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return state
+}
+
+// stateConfigMap 创建保存 states 的状态 ConfigMap
+func stateConfigMap(t *testing.T, name string, states map[string]*RangeState) *corev1.ConfigMap {
+	t.Helper()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data:       make(map[string]string),
+	}
+	for rangeName, state := range states {
+		data, err := encodeState(rangeName, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range data {
+			cm.Data[key] = value
+		}
+	}
+	return cm
+}
+
+// TestUpdateStateMergesConflictingWrites 其他副本在读取之后抢先写入时，
+// 基于最新状态重新执行修改，双方的端口都不会丢失；冲突重试耗尽时返回 ErrStorageUnavailable
+func TestUpdateStateMergesConflictingWrites(t *testing.T) {
+	tests := []struct {
+		name       string
+		concurrent int // 在本副本写入前抢先写入的次数
+		wantOwners map[int32]string
+		wantErr    error
+	}{
+		{
+			name:       "no conflict",
+			wantOwners: map[int32]string{30000: "team/a", 30001: "team/web"},
+		},
+		{
+			name:       "one conflicting write",
+			concurrent: 1,
+			wantOwners: map[int32]string{30000: "team/a", 30001: "team/web", 30005: "team/other"},
+		},
+		{
+			name:       "two conflicting writes",
+			concurrent: 2,
+			wantOwners: map[int32]string{30000: "team/a", 30001: "team/web", 30005: "team/other", 30006: "team/other"},
+		},
+		{
+			name:       "retries exhausted",
+			concurrent: 3,
+			wantOwners: map[int32]string{30000: "team/a", 30005: "team/other", 30006: "team/other", 30007: "team/other"},
+			wantErr:    ErrStorageUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var storage *Storage
+			writes := 0
+			funcs := &interceptor.Funcs{
+				Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+					if writes < tt.concurrent {
+						writes++
+						// 模拟其他副本在本副本读取之后写入，本次写入携带的 resourceVersion 随之过期
+						latest := &corev1.ConfigMap{}
+						if err := c.Get(ctx, client.ObjectKeyFromObject(obj), latest); err != nil {
+							return err
+						}
+						state, err := storage.decodeState(latest, "team", 30000, 30009)
+						if err != nil {
+							return err
+						}
+						if err := state.Set(30004+int32(writes), "team/other"); err != nil {
+							return err
+						}
+						data, err := encodeState("team", state)
+						if err != nil {
+							return err
+						}
+						for key, value := range data {
+							latest.Data[key] = value
+						}
+						if err := c.Update(ctx, latest); err != nil {
+							return err
+						}
+					}
+					return c.Update(ctx, obj, opts...)
+				},
+			}
+			existing := stateConfigMap(t, testStateName, map[string]*RangeState{
+				"team": testState(t, 30000, 30009, map[int32]string{30000: "team/a"}),
+			})
+			c := newFakeClient(t, funcs, existing)
+			storage = newTestStorage(t, c, 0)
+
+			stored, err := storage.UpdateState(ctx, "team", 30000, 30009, func(state *RangeState) (bool, error) {
+				if state.BitSet.Test(30001) {
+					return false, nil
+				}
+				return true, state.Set(30001, "team/web")
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateState() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(stored.Owners, tt.wantOwners) {
+				t.Errorf("returned owners = %v, want %v", stored.Owners, tt.wantOwners)
+			}
+
+			loaded, err := storage.LoadState(ctx, "team", 30000, 30009)
+			if err != nil {
+				t.Fatalf("LoadState: %v", err)
+			}
+			if !reflect.DeepEqual(loaded.Owners, tt.wantOwners) {
+				t.Errorf("stored owners = %v, want %v", loaded.Owners, tt.wantOwners)
+			}
+		})
+	}
+}
+
+// TestUpdateStateKeepsCorruptedData 存储中的数据损坏时 UpdateState 不覆盖，ResetState 从空状态重写
+func TestUpdateStateKeepsCorruptedData(t *testing.T) {
+	tests := []struct {
+		name      string
+		reset     bool
+		wantPorts []int32
+	}{
+		{name: "update", reset: false},
+		{name: "reset", reset: true, wantPorts: []int32{30001}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			corrupted := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: testStateName, Namespace: testNamespace},
+				Data:       map[string]string{"team": "garbage"},
+			}
+			c := newFakeClient(t, nil, corrupted)
+			storage := newTestStorage(t, c, 0)
+
+			mutate := func(state *RangeState) (bool, error) {
+				return true, state.Set(30001, "team/web")
+			}
+			var err error
+			if tt.reset {
+				_, err = storage.ResetState(ctx, "team", 30000, 30009, mutate)
+			} else {
+				_, err = storage.UpdateState(ctx, "team", 30000, 30009, mutate)
+			}
+
+			var corruption *StateCorruptionError
+			if tt.reset && err != nil {
+				t.Fatalf("ResetState: %v", err)
+			}
+			if !tt.reset && !errors.As(err, &corruption) {
+				t.Fatalf("UpdateState() error = %v, want *StateCorruptionError", err)
+			}
+
+			loaded, err := storage.LoadState(ctx, "team", 30000, 30009)
+			if tt.wantPorts == nil {
+				if !errors.As(err, &corruption) {
+					t.Errorf("LoadState() error = %v, corrupted data was overwritten", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadState: %v", err)
+			}
+			if got := loaded.BitSet.Ports(); !reflect.DeepEqual(got, tt.wantPorts) {
+				t.Errorf("ports = %v, want %v", got, tt.wantPorts)
+			}
+		})
+	}
+}
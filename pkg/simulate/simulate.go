@@ -0,0 +1,246 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
+	"github.com/tiggoins/nodeport-allocator/pkg/utils"
+)
+
+// Dump 集群对象导出（如 kubectl get namespaces,services -A -o json）中的 Namespaces 与 Services
+type Dump struct {
+	Namespaces []corev1.Namespace
+	Services   []corev1.Service
+}
+
+// ReadDump 读取 kubectl 输出的 List 对象，忽略 Namespace 与 Service 之外的对象
+func ReadDump(r io.Reader) (*Dump, error) {
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, fmt.Errorf("解析对象列表失败: %v", err)
+	}
+
+	dump := &Dump{}
+	for i, raw := range list.Items {
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal(raw, &typeMeta); err != nil {
+			return nil, fmt.Errorf("解析第 %d 个对象失败: %v", i, err)
+		}
+
+		switch typeMeta.Kind {
+		case "Namespace":
+			var namespace corev1.Namespace
+			if err := json.Unmarshal(raw, &namespace); err != nil {
+				return nil, fmt.Errorf("解析第 %d 个对象失败: %v", i, err)
+			}
+			dump.Namespaces = append(dump.Namespaces, namespace)
+		case "Service":
+			var service corev1.Service
+			if err := json.Unmarshal(raw, &service); err != nil {
+				return nil, fmt.Errorf("解析第 %d 个对象失败: %v", i, err)
+			}
+			dump.Services = append(dump.Services, service)
+		}
+	}
+	return dump, nil
+}
+
+// NamespaceResult 命名空间映射到的端口范围
+type NamespaceResult struct {
+	Namespace string `json:"namespace"`
+	Range     string `json:"range"`
+	Matched   bool   `json:"matched"`
+}
+
+// ServiceResult Service 的模拟分配结果
+type ServiceResult struct {
+	Namespace string  `json:"namespace"`
+	Name      string  `json:"name"`
+	Range     string  `json:"range"`
+	Matched   bool    `json:"matched"`
+	Ports     []int32 `json:"ports"`
+	Fits      bool    `json:"fits"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// RangeUsage 模拟后端口范围的使用情况
+type RangeUsage struct {
+	Range string `json:"range"`
+	Used  int    `json:"used"`
+	Total int    `json:"total"`
+}
+
+// Result 模拟结果
+type Result struct {
+	Namespaces []NamespaceResult `json:"namespaces"`
+	Services   []ServiceResult   `json:"services"`
+	Ranges     []RangeUsage      `json:"ranges"`
+}
+
+// Run 按配置模拟为导出中的 NodePort Services 分配端口：
+// 先占用已指定的 nodePort，再按分配器的顺序为未指定的端口自动分配
+func Run(cfg *config.Config, dump *Dump) (*Result, error) {
+	result := &Result{Namespaces: []NamespaceResult{}, Services: []ServiceResult{}}
+
+	namespaces := make([]string, 0, len(dump.Namespaces))
+	for _, namespace := range dump.Namespaces {
+		namespaces = append(namespaces, namespace.Name)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		rangeName, _, err := cfg.GetPortRangeForNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+		_, matched := cfg.MatchPortRangeForService(namespace, nil)
+		result.Namespaces = append(result.Namespaces, NamespaceResult{Namespace: namespace, Range: rangeName, Matched: matched})
+	}
+
+	bitSets := make(map[string]*utils.BitSet)
+	for name, portRange := range cfg.PortRanges {
+		bitSets[name] = utils.NewBitSet(portRange.Start, portRange.End)
+	}
+
+	services := make([]corev1.Service, 0, len(dump.Services))
+	for _, service := range dump.Services {
+		if service.Spec.Type == corev1.ServiceTypeNodePort {
+			if service.Namespace == "" {
+				service.Namespace = "default"
+			}
+			services = append(services, service)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	usedBy := make(map[int32]string)
+	for _, service := range services {
+		rangeName, portRange, err := cfg.GetPortRangeForService(service.Namespace, service.Labels)
+		if err != nil {
+			return nil, err
+		}
+		_, matched := cfg.MatchPortRangeForService(service.Namespace, service.Labels)
+
+		serviceResult := ServiceResult{
+			Namespace: service.Namespace, Name: service.Name, Range: rangeName, Matched: matched,
+			Ports: make([]int32, len(service.Spec.Ports)), Fits: true,
+		}
+		serviceName := service.Namespace + "/" + service.Name
+		var reasons []string
+		for i, port := range service.Spec.Ports {
+			if port.NodePort == 0 {
+				continue
+			}
+			serviceResult.Ports[i] = port.NodePort
+			if owner, used := usedBy[port.NodePort]; used && owner != serviceName {
+				reasons = append(reasons, fmt.Sprintf("端口 %d 已被 %s 使用", port.NodePort, owner))
+				continue
+			}
+			usedBy[port.NodePort] = serviceName
+			if port.NodePort < portRange.Start || port.NodePort > portRange.End {
+				if !cfg.AllowOutsideRangePorts {
+					reasons = append(reasons, fmt.Sprintf("端口 %d 超出范围 [%d, %d]", port.NodePort, portRange.Start, portRange.End))
+				}
+				continue
+			}
+			_ = bitSets[rangeName].Set(port.NodePort)
+		}
+
+		if len(reasons) > 0 {
+			serviceResult.Fits = false
+			serviceResult.Reason = strings.Join(reasons, "; ")
+		}
+		result.Services = append(result.Services, serviceResult)
+	}
+
+	for i := range result.Services {
+		serviceResult := &result.Services[i]
+		bitSet := bitSets[serviceResult.Range]
+		for j, port := range serviceResult.Ports {
+			if port != 0 {
+				continue
+			}
+			allocated, found := bitSet.FindFirstClear()
+			if !found {
+				serviceResult.Fits = false
+				serviceResult.Reason = strings.TrimPrefix(serviceResult.Reason+"; 端口范围 "+serviceResult.Range+" 已满", "; ")
+				break
+			}
+			_ = bitSet.Set(allocated)
+			serviceResult.Ports[j] = allocated
+		}
+	}
+
+	for _, name := range sortedKeys(bitSets) {
+		portRange := cfg.PortRanges[name]
+		result.Ranges = append(result.Ranges, RangeUsage{
+			Range: name, Used: bitSets[name].Count(), Total: int(portRange.End - portRange.Start + 1),
+		})
+	}
+
+	return result, nil
+}
+
+// WriteTable 以表格形式输出模拟结果
+func (r *Result) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "NAMESPACE\tRANGE\tMATCH")
+	for _, namespace := range r.Namespaces {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", namespace.Namespace, namespace.Range, matchLabel(namespace.Matched))
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "SERVICE\tRANGE\tMATCH\tNODEPORTS\tFITS\tREASON")
+	for _, service := range r.Services {
+		ports := make([]string, 0, len(service.Ports))
+		for _, port := range service.Ports {
+			if port == 0 {
+				ports = append(ports, "-")
+			} else {
+				ports = append(ports, fmt.Sprintf("%d", port))
+			}
+		}
+		fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\t%t\t%s\n", service.Namespace, service.Name, service.Range,
+			matchLabel(service.Matched), strings.Join(ports, ","), service.Fits, service.Reason)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "RANGE\tUSED\tTOTAL")
+	for _, usage := range r.Ranges {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", usage.Range, usage.Used, usage.Total)
+	}
+	return tw.Flush()
+}
+
+// matchLabel 输出命中方式
+func matchLabel(matched bool) string {
+	if matched {
+		return "matched"
+	}
+	return "default"
+}
+
+// sortedKeys 排序后的范围名称
+func sortedKeys(bitSets map[string]*utils.BitSet) []string {
+	names := make([]string, 0, len(bitSets))
+	for name := range bitSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
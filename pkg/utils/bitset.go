@@ -1,8 +1,11 @@
 package utils
 
 import (
+    "encoding/base64"
+    "encoding/binary"
     "encoding/json"
     "fmt"
+    "hash/crc32"
 )
 
 // BitSet 位图结构，用于高效的端口分配
@@ -91,6 +94,19 @@ func (bs *BitSet) Count() int {
     return count
 }
 
+// Ports 返回所有已设置的端口（升序）
+func (bs *BitSet) Ports() []int32 {
+    var ports []int32
+    for wordIndex, word := range bs.bits {
+        for bitIndex := 0; word != 0 && bitIndex < bitsPerWord; bitIndex++ {
+            if (word & (1 << bitIndex)) != 0 {
+                ports = append(ports, bs.offset+int32(wordIndex*bitsPerWord+bitIndex))
+            }
+        }
+    }
+    return ports
+}
+
 // popCount 计算uint64中1的个数
 func popCount(x uint64) int {
     count := 0
@@ -101,52 +117,114 @@ func popCount(x uint64) int {
     return count
 }
 
-// ToJSON 序列化为JSON
+// 位图持久化格式版本
+// 版本 1（无 version 字段）：bits 为 uint64 的 JSON 数组
+// 版本 2：bits 为小端序 uint64 字节流的 base64 编码，附带 CRC32-C 校验和
+const (
+    bitSetLegacyVersion  = 1
+    BitSetFormatVersion  = 2
+    bitSetEncodingBase64 = "base64-le64"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// bitSetDocument 位图持久化文档
+type bitSetDocument struct {
+    Version  int             `json:"version,omitempty"`
+    Encoding string          `json:"encoding,omitempty"`
+    Size     int             `json:"size"`
+    Offset   int32           `json:"offset"`
+    Bits     json.RawMessage `json:"bits"`
+    Checksum string          `json:"checksum,omitempty"`
+}
+
+// ToJSON 以当前版本格式序列化为JSON
 func (bs *BitSet) ToJSON() ([]byte, error) {
-    data := map[string]interface{}{
-        "bits":   bs.bits,
-        "size":   bs.size,
-        "offset": bs.offset,
+    raw := bs.wordBytes()
+    bits, err := json.Marshal(base64.StdEncoding.EncodeToString(raw))
+    if err != nil {
+        return nil, err
     }
-    return json.Marshal(data)
+
+    return json.Marshal(bitSetDocument{
+        Version:  BitSetFormatVersion,
+        Encoding: bitSetEncodingBase64,
+        Size:     bs.size,
+        Offset:   bs.offset,
+        Bits:     bits,
+        Checksum: checksum(bs.offset, bs.size, raw),
+    })
 }
 
-// FromJSON 从JSON反序列化
+// FromJSON 从JSON反序列化，兼容读取版本 1 的数组格式
 func (bs *BitSet) FromJSON(data []byte) error {
-    var temp map[string]interface{}
-    if err := json.Unmarshal(data, &temp); err != nil {
+    var doc bitSetDocument
+    if err := json.Unmarshal(data, &doc); err != nil {
         return err
     }
-    
-    // 转换bits
-    bitsInterface, ok := temp["bits"].([]interface{})
-    if !ok {
-        return fmt.Errorf("invalid bits format")
+
+    if doc.Size < 0 {
+        return fmt.Errorf("invalid size %d", doc.Size)
     }
-    
-    bits := make([]uint64, len(bitsInterface))
-    for i, v := range bitsInterface {
-        if f, ok := v.(float64); ok {
-            bits[i] = uint64(f)
-        } else {
-            return fmt.Errorf("invalid bit value at index %d", i)
+    wordsNeeded := (doc.Size + bitsPerWord - 1) / bitsPerWord
+
+    var bits []uint64
+    switch doc.Version {
+    case 0, bitSetLegacyVersion:
+        // 直接解码为 uint64，避免经过 float64 丢失 2^53 以上的精度
+        if err := json.Unmarshal(doc.Bits, &bits); err != nil {
+            return fmt.Errorf("invalid bits format: %v", err)
         }
+    case BitSetFormatVersion:
+        if doc.Encoding != bitSetEncodingBase64 {
+            return fmt.Errorf("unsupported bits encoding %q", doc.Encoding)
+        }
+        var encoded string
+        if err := json.Unmarshal(doc.Bits, &encoded); err != nil {
+            return fmt.Errorf("invalid bits format: %v", err)
+        }
+        raw, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil {
+            return fmt.Errorf("invalid bits encoding: %v", err)
+        }
+        if len(raw) != wordsNeeded*8 {
+            return fmt.Errorf("invalid bits length %d, expected %d", len(raw), wordsNeeded*8)
+        }
+        if sum := checksum(doc.Offset, doc.Size, raw); sum != doc.Checksum {
+            return fmt.Errorf("checksum mismatch: stored %s, computed %s", doc.Checksum, sum)
+        }
+        bits = make([]uint64, wordsNeeded)
+        for i := range bits {
+            bits[i] = binary.LittleEndian.Uint64(raw[i*8:])
+        }
+    default:
+        return fmt.Errorf("unsupported bitset format version %d", doc.Version)
     }
-    
-    size, ok := temp["size"].(float64)
-    if !ok {
-        return fmt.Errorf("invalid size format")
-    }
-    
-    offset, ok := temp["offset"].(float64)
-    if !ok {
-        return fmt.Errorf("invalid offset format")
+
+    if len(bits) != wordsNeeded {
+        return fmt.Errorf("invalid bits count %d, expected %d", len(bits), wordsNeeded)
     }
-    
+
     bs.bits = bits
-    bs.size = int(size)
-    bs.offset = int32(offset)
-    
+    bs.size = doc.Size
+    bs.offset = doc.Offset
+
     return nil
 }
 
+// wordBytes 以小端序输出位图字节流
+func (bs *BitSet) wordBytes() []byte {
+    raw := make([]byte, len(bs.bits)*8)
+    for i, word := range bs.bits {
+        binary.LittleEndian.PutUint64(raw[i*8:], word)
+    }
+    return raw
+}
+
+// checksum 计算覆盖范围元数据与位图内容的 CRC32-C 校验和
+func checksum(offset int32, size int, raw []byte) string {
+    h := crc32.New(crc32cTable)
+    fmt.Fprintf(h, "%d:%d:", offset, size)
+    h.Write(raw)
+    return fmt.Sprintf("crc32c:%08x", h.Sum32())
+}
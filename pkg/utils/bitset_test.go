@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTrip 序列化后重新解析位图
+func roundTrip(t *testing.T, bs *BitSet) *BitSet {
+	t.Helper()
+	data, err := bs.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	decoded := &BitSet{}
+	if err := decoded.FromJSON(data); err != nil {
+		t.Fatalf("FromJSON: %v", err)
+	}
+	return decoded
+}
+
+func TestBitSetRoundTripWordBoundaries(t *testing.T) {
+	// 130 个端口占用 3 个 word，最后一个 word 只使用 2 位
+	bs := NewBitSet(0, 129)
+	ports := []int32{0, 63, 64, 127, 128, 129}
+	for _, port := range ports {
+		if err := bs.Set(port); err != nil {
+			t.Fatalf("Set(%d): %v", port, err)
+		}
+	}
+
+	decoded := roundTrip(t, bs)
+	if got := decoded.Ports(); !reflect.DeepEqual(got, ports) {
+		t.Errorf("Ports() = %v, want %v", got, ports)
+	}
+	for _, port := range []int32{1, 62, 65, 126} {
+		if decoded.Test(port) {
+			t.Errorf("Test(%d) = true, want false", port)
+		}
+	}
+	if port, found := decoded.FindFirstClear(); !found || port != 1 {
+		t.Errorf("FindFirstClear() = %d, %v, want 1, true", port, found)
+	}
+}
+
+func TestBitSetRoundTripOffset(t *testing.T) {
+	bs := NewBitSet(30000, 30099)
+	ports := []int32{30000, 30063, 30064, 30099}
+	for _, port := range ports {
+		if err := bs.Set(port); err != nil {
+			t.Fatalf("Set(%d): %v", port, err)
+		}
+	}
+
+	decoded := roundTrip(t, bs)
+	if got := decoded.Ports(); !reflect.DeepEqual(got, ports) {
+		t.Errorf("Ports() = %v, want %v", got, ports)
+	}
+	if decoded.Test(29999) || decoded.Test(30100) {
+		t.Error("ports outside the range are reported as used")
+	}
+	if err := decoded.Set(30100); err == nil {
+		t.Error("Set(30100) succeeded outside the range")
+	}
+}
+
+func TestBitSetReadsLegacyFormat(t *testing.T) {
+	// 版本 1：bits 为 uint64 的 JSON 数组，最高位需要保持精度
+	legacy := `{"size":128,"offset":30000,"bits":[1,9223372036854775808]}`
+
+	bs := &BitSet{}
+	if err := bs.FromJSON([]byte(legacy)); err != nil {
+		t.Fatalf("FromJSON: %v", err)
+	}
+	want := []int32{30000, 30127}
+	if got := bs.Ports(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ports() = %v, want %v", got, want)
+	}
+
+	// 重新写入时升级为当前版本
+	data, err := bs.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	var doc bitSetDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if doc.Version != BitSetFormatVersion {
+		t.Errorf("version = %d, want %d", doc.Version, BitSetFormatVersion)
+	}
+}
+
+func TestBitSetDetectsChecksumMismatch(t *testing.T) {
+	bs := NewBitSet(30000, 30099)
+	if err := bs.Set(30010); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	data, err := bs.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	// 修改偏移量而不更新校验和，校验和覆盖范围元数据
+	doc["offset"] = 30001
+	tampered, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	err = (&BitSet{}).FromJSON(tampered)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("FromJSON() error = %v, want checksum mismatch", err)
+	}
+}
+
+func TestBitSetRejectsWrongSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"negative size", `{"size":-1,"offset":30000,"bits":[]}`},
+		{"legacy too few words", `{"size":128,"offset":30000,"bits":[1]}`},
+		{"legacy too many words", `{"size":64,"offset":30000,"bits":[1,2]}`},
+		// 8 字节只够 64 个端口
+		{"base64 wrong length", `{"version":2,"encoding":"base64-le64","size":65,"offset":30000,"bits":"AQAAAAAAAAA=","checksum":"crc32c:00000000"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&BitSet{}).FromJSON([]byte(tt.data)); err == nil {
+				t.Error("FromJSON() succeeded, want error")
+			}
+		})
+	}
+}
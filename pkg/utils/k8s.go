@@ -3,12 +3,16 @@ package utils
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tiggoins/nodeport-allocator/pkg/config"
 )
 
 // RetryOnConflict 使用指数退避重试机制，处理更新冲突
@@ -77,3 +81,37 @@ func GetNamespaceFromObject(obj metav1.Object) string {
 	}
 	return ns
 }
+
+// apiServerNodePortRangeFlag kube-apiserver 的 NodePort 范围参数
+const apiServerNodePortRangeFlag = "--service-node-port-range"
+
+// DiscoverNodePortRange 从 kube-system 中 kube-apiserver 静态 Pod 的启动参数读取 NodePort 范围
+// 找到 Pod 但未设置该参数时返回 apiserver 的默认值；托管集群中通常找不到 Pod，此时返回错误
+// 返回参数的原始值，可能为 <start>-<end> 或 <base>+<size> 格式，由 config.ParseNodePortRange 解析
+func DiscoverNodePortRange(ctx context.Context, reader client.Reader) (string, error) {
+	var pods corev1.PodList
+	if err := reader.List(ctx, &pods,
+		client.InNamespace("kube-system"),
+		client.MatchingLabels{"component": "kube-apiserver"}); err != nil {
+		return "", fmt.Errorf("列出 kube-apiserver Pod 失败: %v", err)
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("未找到 kube-apiserver Pod")
+	}
+
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			args := append(append([]string{}, container.Command...), container.Args...)
+			for i, arg := range args {
+				if value, ok := strings.CutPrefix(arg, apiServerNodePortRangeFlag+"="); ok {
+					return value, nil
+				}
+				if arg == apiServerNodePortRangeFlag && i+1 < len(args) {
+					return args[i+1], nil
+				}
+			}
+		}
+	}
+
+	return config.DefaultNodePortRange.String(), nil
+}
@@ -2,8 +2,8 @@ package webhook
 
 import (
     "context"
-    "encoding/json"
     "fmt"
+    "io"
     "net/http"
 
     "github.com/go-logr/logr"
@@ -22,6 +22,7 @@ type AdmissionWebhook interface {
 }
 
 // ServeHTTP 实现http.Handler接口
+// 支持 admission.k8s.io/v1 与 v1beta1，按请求的版本返回响应
 func (h *AdmissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     h.Logger.Info("收到Webhook请求", "method", r.Method, "path", r.URL.Path)
 
@@ -32,28 +33,35 @@ func (h *AdmissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     }
 
     contentType := r.Header.Get("Content-Type")
-    if contentType != "application/json" {
-        h.Logger.Info("拒绝非JSON请求", "contentType", contentType)
-        http.Error(w, "Content-Type必须为application/json", http.StatusBadRequest)
+    if err := checkContentType(contentType); err != nil {
+        h.Logger.Info("拒绝不支持的Content-Type", "contentType", contentType, "reason", err.Error())
+        http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
         return
     }
 
-    var admissionReview admissionv1.AdmissionReview
-    if err := json.NewDecoder(r.Body).Decode(&admissionReview); err != nil {
-        h.Logger.Error(err, "解析AdmissionReview失败")
-        http.Error(w, fmt.Sprintf("解析请求失败: %v", err), http.StatusBadRequest)
+    if accept := r.Header.Get("Accept"); !acceptsJSON(accept) {
+        h.Logger.Info("拒绝不接受JSON响应的请求", "accept", accept)
+        http.Error(w, "只能返回application/json格式的响应", http.StatusNotAcceptable)
         return
     }
 
-    if admissionReview.Request == nil {
-        h.Logger.Error(fmt.Errorf("AdmissionRequest为空"), "无效的AdmissionReview")
-        http.Error(w, "AdmissionRequest为空", http.StatusBadRequest)
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        h.Logger.Error(err, "读取请求失败")
+        http.Error(w, fmt.Sprintf("读取请求失败: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    gvk, req, err := decodeAdmissionReview(body)
+    if err != nil {
+        h.Logger.Error(err, "解析AdmissionReview失败")
+        http.Error(w, fmt.Sprintf("解析请求失败: %v", err), http.StatusBadRequest)
         return
     }
 
     // 记录请求详情
-    req := admissionReview.Request
     h.Logger.Info("处理准入请求",
+        "apiVersion", gvk.GroupVersion(),
         "uid", req.UID,
         "kind", req.Kind,
         "namespace", req.Namespace,
@@ -71,15 +79,18 @@ func (h *AdmissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     // 设置响应UID
     response.UID = req.UID
 
-    // 构建响应
-    admissionReview.Response = response
-    admissionReview.Request = nil // 清除请求以减少响应大小
+    // 构建响应（不包含请求以减少响应大小）
+    data, err := encodeAdmissionReview(gvk, response)
+    if err != nil {
+        h.Logger.Error(err, "编码响应失败")
+        http.Error(w, fmt.Sprintf("编码响应失败: %v", err), http.StatusInternalServerError)
+        return
+    }
 
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
-
-    if err := json.NewEncoder(w).Encode(admissionReview); err != nil {
-        h.Logger.Error(err, "编码响应失败")
+    if _, err := w.Write(data); err != nil {
+        h.Logger.Error(err, "写入响应失败")
         // 此时已经写入了状态码，无法再次设置错误响应
         return
     }
@@ -89,4 +100,3 @@ func (h *AdmissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
         "allowed", response.Allowed,
         "warnings", len(response.Warnings))
 }
-
@@ -0,0 +1,171 @@
+package webhook
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+
+    "github.com/go-logr/logr"
+    admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "sigs.k8s.io/controller-runtime/pkg/client"
+
+    "github.com/tiggoins/nodeport-allocator/pkg/config"
+)
+
+// Webhook 处理路径
+const (
+    MutatePath   = "/mutate"
+    ValidatePath = "/validate"
+)
+
+// Registrar 创建/更新本分配器的 MutatingWebhookConfiguration 与 ValidatingWebhookConfiguration
+type Registrar struct {
+    Client client.Client
+    Reader client.Reader
+    Config config.WebhookConfig
+    Logger logr.Logger
+}
+
+// Register 以 caBundle 创建或更新 Webhook 配置，内容一致时不写入
+func (r *Registrar) Register(ctx context.Context, caBundle []byte) error {
+    if err := r.registerMutating(ctx, caBundle); err != nil {
+        return fmt.Errorf("注册MutatingWebhookConfiguration失败: %v", err)
+    }
+    if err := r.registerValidating(ctx, caBundle); err != nil {
+        return fmt.Errorf("注册ValidatingWebhookConfiguration失败: %v", err)
+    }
+    return nil
+}
+
+func (r *Registrar) registerMutating(ctx context.Context, caBundle []byte) error {
+    webhooks := []admissionregistrationv1.MutatingWebhook{{
+        Name:                    "mutate.nodeport-allocator.example.com",
+        ClientConfig:            r.clientConfig(MutatePath, caBundle),
+        Rules:                   serviceRules(),
+        AdmissionReviewVersions: admissionReviewVersions,
+        SideEffects:             sideEffects(),
+        FailurePolicy:           r.failurePolicy(),
+    }}
+
+    existing := &admissionregistrationv1.MutatingWebhookConfiguration{}
+    err := r.Reader.Get(ctx, client.ObjectKey{Name: r.Config.ConfigurationName}, existing)
+    if apierrors.IsNotFound(err) {
+        r.Logger.Info("创建MutatingWebhookConfiguration", "name", r.Config.ConfigurationName)
+        return r.Client.Create(ctx, &admissionregistrationv1.MutatingWebhookConfiguration{
+            ObjectMeta: metav1.ObjectMeta{Name: r.Config.ConfigurationName},
+            Webhooks:   webhooks,
+        })
+    }
+    if err != nil {
+        return err
+    }
+
+    // 与已有配置比较时使用 apiserver 填充默认值后的对象，只比较本分配器管理的字段
+    if webhooksEqual(existing.Webhooks, webhooks) {
+        return nil
+    }
+    existing.Webhooks = webhooks
+    r.Logger.Info("更新MutatingWebhookConfiguration", "name", r.Config.ConfigurationName)
+    return r.Client.Update(ctx, existing)
+}
+
+func (r *Registrar) registerValidating(ctx context.Context, caBundle []byte) error {
+    webhooks := []admissionregistrationv1.ValidatingWebhook{{
+        Name:                    "validate.nodeport-allocator.example.com",
+        ClientConfig:            r.clientConfig(ValidatePath, caBundle),
+        Rules:                   serviceRules(),
+        AdmissionReviewVersions: admissionReviewVersions,
+        SideEffects:             sideEffects(),
+        FailurePolicy:           r.failurePolicy(),
+    }}
+
+    existing := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+    err := r.Reader.Get(ctx, client.ObjectKey{Name: r.Config.ConfigurationName}, existing)
+    if apierrors.IsNotFound(err) {
+        r.Logger.Info("创建ValidatingWebhookConfiguration", "name", r.Config.ConfigurationName)
+        return r.Client.Create(ctx, &admissionregistrationv1.ValidatingWebhookConfiguration{
+            ObjectMeta: metav1.ObjectMeta{Name: r.Config.ConfigurationName},
+            Webhooks:   webhooks,
+        })
+    }
+    if err != nil {
+        return err
+    }
+
+    if webhooksEqual(existing.Webhooks, webhooks) {
+        return nil
+    }
+    existing.Webhooks = webhooks
+    r.Logger.Info("更新ValidatingWebhookConfiguration", "name", r.Config.ConfigurationName)
+    return r.Client.Update(ctx, existing)
+}
+
+// admissionReviewVersions Webhook 支持的 AdmissionReview 版本，apiserver 按顺序选择第一个支持的版本
+var admissionReviewVersions = []string{"v1", "v1beta1"}
+
+func (r *Registrar) clientConfig(path string, caBundle []byte) admissionregistrationv1.WebhookClientConfig {
+    port := r.Config.ServicePort
+    return admissionregistrationv1.WebhookClientConfig{
+        Service: &admissionregistrationv1.ServiceReference{
+            Name:      r.Config.ServiceName,
+            Namespace: r.Config.ServiceNamespace,
+            Path:      &path,
+            Port:      &port,
+        },
+        CABundle: caBundle,
+    }
+}
+
+func (r *Registrar) failurePolicy() *admissionregistrationv1.FailurePolicyType {
+    policy := admissionregistrationv1.FailurePolicyType(r.Config.FailurePolicy)
+    return &policy
+}
+
+func serviceRules() []admissionregistrationv1.RuleWithOperations {
+    // 显式设置 apiserver 会填充的默认值，避免每次比较都认为配置已变化
+    scope := admissionregistrationv1.NamespacedScope
+    return []admissionregistrationv1.RuleWithOperations{{
+        Operations: []admissionregistrationv1.OperationType{
+            admissionregistrationv1.Create,
+            admissionregistrationv1.Update,
+        },
+        Rule: admissionregistrationv1.Rule{
+            APIGroups:   []string{""},
+            APIVersions: []string{"v1"},
+            Resources:   []string{"services"},
+            Scope:       &scope,
+        },
+    }}
+}
+
+func sideEffects() *admissionregistrationv1.SideEffectClass {
+    // 变更 Webhook 会写入端口状态；dryRun 请求只基于缓存预选端口，不写入存储
+    sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+    return &sideEffects
+}
+
+// webhooksEqual 比较本分配器管理的字段：名称、clientConfig、规则、版本、副作用与失败策略
+func webhooksEqual[T admissionregistrationv1.MutatingWebhook | admissionregistrationv1.ValidatingWebhook](existing, desired []T) bool {
+    if len(existing) != len(desired) {
+        return false
+    }
+    for i := range existing {
+        if !reflect.DeepEqual(managedFields(existing[i]), managedFields(desired[i])) {
+            return false
+        }
+    }
+    return true
+}
+
+// managedFields 提取 Webhook 中由本分配器设置的字段
+func managedFields(webhook interface{}) []interface{} {
+    switch w := webhook.(type) {
+    case admissionregistrationv1.MutatingWebhook:
+        return []interface{}{w.Name, w.ClientConfig, w.Rules, w.AdmissionReviewVersions, w.SideEffects, w.FailurePolicy}
+    case admissionregistrationv1.ValidatingWebhook:
+        return []interface{}{w.Name, w.ClientConfig, w.Rules, w.AdmissionReviewVersions, w.SideEffects, w.FailurePolicy}
+    }
+    return nil
+}
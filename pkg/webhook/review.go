@@ -0,0 +1,114 @@
+package webhook
+
+import (
+    "encoding/json"
+    "fmt"
+    "mime"
+    "strings"
+
+    admissionv1 "k8s.io/api/admission/v1"
+    admissionv1beta1 "k8s.io/api/admission/v1beta1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// checkContentType 按媒体类型解析 Content-Type，允许携带参数（如 charset=utf-8）
+func checkContentType(contentType string) error {
+    mediaType, params, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return fmt.Errorf("无效的Content-Type %q: %v", contentType, err)
+    }
+    if mediaType != "application/json" {
+        return fmt.Errorf("Content-Type必须为application/json，实际为 %s", mediaType)
+    }
+    if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+        return fmt.Errorf("不支持的字符集 %s，只支持utf-8", charset)
+    }
+    return nil
+}
+
+// acceptsJSON 判断 Accept 是否接受 application/json，未设置时视为接受
+func acceptsJSON(accept string) bool {
+    if strings.TrimSpace(accept) == "" {
+        return true
+    }
+    for _, part := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+        if err != nil || params["q"] == "0" {
+            continue
+        }
+        switch mediaType {
+        case "application/json", "application/*", "*/*":
+            return true
+        }
+    }
+    return false
+}
+
+// decodeAdmissionReview 解析 AdmissionReview，返回请求的版本与统一转换为 v1 的请求
+func decodeAdmissionReview(body []byte) (schema.GroupVersionKind, *admissionv1.AdmissionRequest, error) {
+    var typeMeta metav1.TypeMeta
+    if err := json.Unmarshal(body, &typeMeta); err != nil {
+        return schema.GroupVersionKind{}, nil, err
+    }
+    gvk := typeMeta.GroupVersionKind()
+    if gvk.Kind != "AdmissionReview" {
+        return gvk, nil, fmt.Errorf("不支持的类型 %s", gvk.Kind)
+    }
+
+    var req *admissionv1.AdmissionRequest
+    switch gvk.GroupVersion() {
+    case admissionv1.SchemeGroupVersion:
+        var review admissionv1.AdmissionReview
+        if err := json.Unmarshal(body, &review); err != nil {
+            return gvk, nil, err
+        }
+        req = review.Request
+
+    case admissionv1beta1.SchemeGroupVersion:
+        var review admissionv1beta1.AdmissionReview
+        if err := json.Unmarshal(body, &review); err != nil {
+            return gvk, nil, err
+        }
+        if review.Request != nil {
+            req = &admissionv1.AdmissionRequest{}
+            if err := convert(review.Request, req); err != nil {
+                return gvk, nil, err
+            }
+        }
+
+    default:
+        return gvk, nil, fmt.Errorf("不支持的AdmissionReview版本 %s", gvk.GroupVersion())
+    }
+
+    if req == nil {
+        return gvk, nil, fmt.Errorf("AdmissionRequest为空")
+    }
+    return gvk, req, nil
+}
+
+// encodeAdmissionReview 按请求的版本编码响应
+func encodeAdmissionReview(gvk schema.GroupVersionKind, response *admissionv1.AdmissionResponse) ([]byte, error) {
+    if gvk.GroupVersion() == admissionv1beta1.SchemeGroupVersion {
+        converted := &admissionv1beta1.AdmissionResponse{}
+        if err := convert(response, converted); err != nil {
+            return nil, err
+        }
+        review := admissionv1beta1.AdmissionReview{Response: converted}
+        review.SetGroupVersionKind(gvk)
+        return json.Marshal(review)
+    }
+
+    review := admissionv1.AdmissionReview{Response: response}
+    review.SetGroupVersionKind(gvk)
+    return json.Marshal(review)
+}
+
+// convert 在 v1 与 v1beta1 之间转换，两个版本的请求和响应字段及序列化格式一致
+func convert(in, out interface{}) error {
+    data, err := json.Marshal(in)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, out)
+}
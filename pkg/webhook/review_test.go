@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// echoWebhook 记录收到的请求并返回带补丁的允许响应
+type echoWebhook struct {
+	req *admissionv1.AdmissionRequest
+}
+
+func (w *echoWebhook) Handle(_ context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	w.req = req
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     []byte(`[{"op":"add","path":"/spec/ports/0/nodePort","value":30000}]`),
+		PatchType: &patchType,
+		Warnings:  []string{"warning"},
+	}
+}
+
+// reviewBody 构造指定版本的 AdmissionReview 请求体
+func reviewBody(apiVersion string) string {
+	return `{"apiVersion":"` + apiVersion + `","kind":"AdmissionReview","request":{` +
+		`"uid":"uid-1","kind":{"group":"","version":"v1","kind":"Service"},` +
+		`"resource":{"group":"","version":"v1","resource":"services"},` +
+		`"namespace":"team","name":"web","operation":"CREATE","userInfo":{"username":"alice"},` +
+		`"object":{"apiVersion":"v1","kind":"Service","metadata":{"name":"web","namespace":"team"}}}}`
+}
+
+func TestAdmissionHandlerNegotiation(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		accept      string
+		body        string
+		wantStatus  int
+		// wantVersion 响应的 AdmissionReview 版本，仅在 200 时检查
+		wantVersion string
+	}{
+		{
+			name:        "v1",
+			contentType: "application/json",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusOK,
+			wantVersion: "admission.k8s.io/v1",
+		},
+		{
+			name:        "v1beta1",
+			contentType: "application/json",
+			body:        reviewBody("admission.k8s.io/v1beta1"),
+			wantStatus:  http.StatusOK,
+			wantVersion: "admission.k8s.io/v1beta1",
+		},
+		{
+			name:        "content type with charset",
+			contentType: "application/json; charset=UTF-8",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusOK,
+			wantVersion: "admission.k8s.io/v1",
+		},
+		{
+			name:        "accept with lower preference for json",
+			contentType: "application/json",
+			accept:      "application/yaml, application/json;q=0.5",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusOK,
+			wantVersion: "admission.k8s.io/v1",
+		},
+		{
+			name:        "accept wildcard",
+			contentType: "application/json",
+			accept:      "*/*",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusOK,
+			wantVersion: "admission.k8s.io/v1",
+		},
+		{
+			name:       "missing content type",
+			body:       reviewBody("admission.k8s.io/v1"),
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "application/yaml",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "wrong charset",
+			contentType: "application/json; charset=latin1",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "json not acceptable",
+			contentType: "application/json",
+			accept:      "application/yaml",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusNotAcceptable,
+		},
+		{
+			name:        "json refused with q=0",
+			contentType: "application/json",
+			accept:      "application/json;q=0",
+			body:        reviewBody("admission.k8s.io/v1"),
+			wantStatus:  http.StatusNotAcceptable,
+		},
+		{
+			name:        "unsupported version",
+			contentType: "application/json",
+			body:        reviewBody("admission.k8s.io/v2"),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "missing request",
+			contentType: "application/json",
+			body:        `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := &echoWebhook{}
+			handler := &AdmissionHandler{Handler: webhook, Logger: logr.Discard()}
+
+			r := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if webhook.req != nil {
+					t.Error("rejected request reached the webhook")
+				}
+				return
+			}
+
+			// 请求统一转换为 v1 交给处理器
+			if webhook.req.UID != types.UID("uid-1") || webhook.req.Operation != admissionv1.Create ||
+				webhook.req.Namespace != "team" || webhook.req.UserInfo.Username != "alice" ||
+				len(webhook.req.Object.Raw) == 0 {
+				t.Errorf("decoded request = %+v", webhook.req)
+			}
+
+			// 响应使用请求的版本，补丁等字段在转换中保持不变
+			var review struct {
+				APIVersion string `json:"apiVersion"`
+				Kind       string `json:"kind"`
+				Response   struct {
+					UID       string   `json:"uid"`
+					Allowed   bool     `json:"allowed"`
+					Patch     []byte   `json:"patch"`
+					PatchType string   `json:"patchType"`
+					Warnings  []string `json:"warnings"`
+				} `json:"response"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if review.APIVersion != tt.wantVersion || review.Kind != "AdmissionReview" {
+				t.Errorf("response type = %s %s, want %s AdmissionReview", review.APIVersion, review.Kind, tt.wantVersion)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("response Content-Type = %q", got)
+			}
+			want := `[{"op":"add","path":"/spec/ports/0/nodePort","value":30000}]`
+			if review.Response.UID != "uid-1" || !review.Response.Allowed || string(review.Response.Patch) != want ||
+				review.Response.PatchType != "JSONPatch" || !reflect.DeepEqual(review.Response.Warnings, []string{"warning"}) {
+				t.Errorf("response = %+v", review.Response)
+			}
+		})
+	}
+}
+
+func TestAdmissionHandlerRejectsNonPost(t *testing.T) {
+	handler := &AdmissionHandler{Handler: &echoWebhook{}, Logger: logr.Discard()}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mutate", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}